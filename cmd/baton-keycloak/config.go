@@ -1,42 +1,125 @@
 package main
 
 import (
+	"fmt"
+	"net/url"
+	"strings"
+
 	"github.com/conductorone/baton-sdk/pkg/field"
 	"github.com/spf13/viper"
+	connectorSchema "github.com/spiros-spiros/baton-keycloak/pkg/connector"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
 )
 
 var (
-	keycloakServerURLField = field.StringField(
-		"keycloak-server-url",
-		field.WithDescription("The URL of the Keycloak server."),
-		field.WithDefaultValue("https://keycloak.com/"),
-		field.WithRequired(true),
-	)
-	keycloakRealmField = field.StringField(
-		"keycloak-realm",
-		field.WithDescription("The realm of the Keycloak server."),
-		field.WithRequired(true),
-	)
-	keycloakClientIDField = field.StringField(
-		"keycloak-client-id",
-		field.WithDescription("The client ID you made."),
-		field.WithRequired(true),
-	)
-	keycloakClientSecretField = field.StringField(
-		"keycloak-client-secret",
-		field.WithDescription("The client secret for the client you made."),
-		field.WithRequired(true),
-	)
-
-	ConfigurationFields = []field.SchemaField{keycloakServerURLField, keycloakRealmField, keycloakClientIDField, keycloakClientSecretField}
-
-	FieldRelationships = []field.SchemaFieldRelationship{}
+	apiUrlField                    = field.StringField("api_url", field.WithDescription("The URL of the Keycloak server"))
+	realmField                     = field.StringField("realm", field.WithDescription("The realm to sync, a comma separated list of realms, or * for every realm the client can see"))
+	authRealmField                 = field.StringField("auth_realm", field.WithDescription("The realm to authenticate against, such as master, if the client lives in a different realm than the one being synced. Required when syncing more than one realm"))
+	keycloakclientField            = field.StringField("keycloak_client_id", field.WithDescription("The client ID to use for authentication"))
+	keycloakclientSecretField      = field.StringField("keycloak_client_secret", field.WithDescription("The client secret to use for authentication, required for the client_secret auth method"), field.WithIsSecret(true))
+	authMethodField                = field.StringField("auth_method", field.WithDescription("How to authenticate: client_secret, private_key_jwt, mtls or password"), field.WithDefaultValue(string(keycloak.AuthClientSecret)))
+	privateKeyPathField            = field.StringField("private_key_path", field.WithDescription("Path to the PEM encoded private key used to sign client assertions for private_key_jwt"))
+	clientCertificatePathField     = field.StringField("client_certificate_path", field.WithDescription("Path to the PEM encoded client certificate for mtls"))
+	clientKeyPathField             = field.StringField("client_key_path", field.WithDescription("Path to the PEM encoded private key of the client certificate for mtls"))
+	usernameField                  = field.StringField("username", field.WithDescription("The admin username for the password auth method"))
+	passwordField                  = field.StringField("password", field.WithDescription("The admin password for the password auth method"), field.WithIsSecret(true))
+	caBundlePathField              = field.StringField("ca_bundle_path", field.WithDescription("Path to a PEM file of additional CA certificates to trust, such as an internal CA"))
+	insecureSkipVerifyField        = field.BoolField("insecure_skip_verify", field.WithDescription("Disable TLS certificate verification. Only for lab environments"))
+	proxyURLField                  = field.StringField("proxy_url", field.WithDescription("HTTP or HTTPS proxy to reach Keycloak through, overriding HTTP_PROXY/HTTPS_PROXY"))
+	requestTimeoutField            = field.IntField("request_timeout", field.WithDescription("Timeout of a single request to Keycloak in seconds, 0 for none"), field.WithDefaultValue(60))
+	directGroupMembershipOnlyField = field.BoolField("direct_group_membership_only", field.WithDescription("Only sync direct group membership instead of also expanding subgroup members into their parent groups"))
+	disableUsersOnDeleteField      = field.BoolField("disable_users_on_delete", field.WithDescription("Disable users and log out their sessions instead of deleting them when deprovisioning"))
+	legacyUserIDsField             = field.BoolField("legacy_user_ids", field.WithDescription("Accept the username-based user IDs of older connector versions in grant and revoke requests"))
+	maxAttemptsField               = field.IntField("max_attempts", field.WithDescription("How often a request rate limited (429) or rejected as unavailable (503) by Keycloak is sent before giving up"), field.WithDefaultValue(keycloak.DefaultMaxAttempts))
+	pageSizeField                  = field.IntField("page_size", field.WithDescription(fmt.Sprintf("The number of users, groups, roles or clients fetched per request (%d-%d)", keycloak.MinPageSize, keycloak.MaxPageSize)), field.WithDefaultValue(keycloak.DefaultPageSize))
+
+	// Names used by earlier versions of the connector, still accepted so
+	// existing deployments keep working. The fields above take precedence.
+	keycloakServerURLField    = field.StringField("keycloak-server-url", field.WithDescription("Deprecated: use api_url"), field.WithHidden(true))
+	keycloakRealmField        = field.StringField("keycloak-realm", field.WithDescription("Deprecated: use realm"), field.WithHidden(true))
+	keycloakClientIDField     = field.StringField("keycloak-client-id", field.WithDescription("Deprecated: use keycloak_client_id"), field.WithHidden(true))
+	keycloakClientSecretField = field.StringField("keycloak-client-secret", field.WithDescription("Deprecated: use keycloak_client_secret"), field.WithHidden(true), field.WithIsSecret(true))
+
+	ConfigurationFields = []field.SchemaField{
+		apiUrlField,
+		realmField,
+		authRealmField,
+		keycloakclientField,
+		keycloakclientSecretField,
+		authMethodField,
+		privateKeyPathField,
+		clientCertificatePathField,
+		clientKeyPathField,
+		usernameField,
+		passwordField,
+		directGroupMembershipOnlyField,
+		legacyUserIDsField,
+		disableUsersOnDeleteField,
+		maxAttemptsField,
+		pageSizeField,
+		caBundlePathField,
+		insecureSkipVerifyField,
+		proxyURLField,
+		requestTimeoutField,
+		keycloakServerURLField,
+		keycloakRealmField,
+		keycloakClientIDField,
+		keycloakClientSecretField,
+	}
+
+	FieldRelationships = []field.SchemaFieldRelationship{
+		field.FieldsAtLeastOneUsed(apiUrlField, keycloakServerURLField),
+		field.FieldsAtLeastOneUsed(realmField, keycloakRealmField),
+		field.FieldsAtLeastOneUsed(keycloakclientField, keycloakClientIDField),
+	}
 )
 
+// getString returns the value of f, falling back to its deprecated alias.
+func getString(v *viper.Viper, f, alias field.SchemaField) string {
+	if s := v.GetString(f.FieldName); s != "" {
+		return s
+	}
+	return v.GetString(alias.FieldName)
+}
+
 // ValidateConfig is run after the configuration is loaded, and should return an
 // error if it isn't valid. Implementing this function is optional, it only
 // needs to perform extra validations that cannot be encoded with configuration
 // parameters.
 func ValidateConfig(v *viper.Viper) error {
+	serverURL := getString(v, apiUrlField, keycloakServerURLField)
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", apiUrlField.FieldName, serverURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s %q: must be an http or https URL such as https://keycloak.example.com", apiUrlField.FieldName, serverURL)
+	}
+
+	realms, _, err := connectorSchema.ParseRealms(strings.Split(getString(v, realmField, keycloakRealmField), ","))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", realmField.FieldName, err)
+	}
+	for _, realm := range realms {
+		if err := validateRealmName(realm); err != nil {
+			return fmt.Errorf("invalid %s: %w", realmField.FieldName, err)
+		}
+	}
+
+	if authRealm := v.GetString(authRealmField.FieldName); authRealm != "" {
+		if err := validateRealmName(authRealm); err != nil {
+			return fmt.Errorf("invalid %s: %w", authRealmField.FieldName, err)
+		}
+	}
+
+	return nil
+}
+
+// validateRealmName rejects names that cannot be a Keycloak realm because they
+// would change the admin API path they are used in.
+func validateRealmName(realm string) error {
+	if strings.ContainsAny(realm, "/\\?#% \t\r\n") {
+		return fmt.Errorf("realm name %q must not contain slashes, whitespace or any of ?#%%", realm)
+	}
 	return nil
-}
\ No newline at end of file
+}
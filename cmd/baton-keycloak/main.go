@@ -7,6 +7,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/conductorone/baton-sdk/pkg/config"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
@@ -18,23 +20,7 @@ import (
 	"go.uber.org/zap"
 )
 
-var (
-	apiUrlField               = field.StringField("api_url", field.WithDescription("The URL of the Keycloak server"), field.WithRequired(true))
-	realmField                = field.StringField("realm", field.WithDescription("The realm to connect to"), field.WithRequired(true))
-	keycloakclientField       = field.StringField("keycloak_client_id", field.WithDescription("The client ID to use for authentication"), field.WithRequired(true))
-	keycloakclientSecretField = field.StringField("keycloak_client_secret", field.WithDescription("The client secret to use for authentication"), field.WithRequired(true))
-	batonClientIDField        = field.StringField("baton_client_id", field.WithDescription("The Baton client ID"), field.WithRequired(true))
-	batonClientSecretField    = field.StringField("baton_client_secret", field.WithDescription("The Baton client secret"), field.WithRequired(true))
-)
-
-var configuration = field.NewConfiguration([]field.SchemaField{
-	apiUrlField,
-	realmField,
-	keycloakclientField,
-	keycloakclientSecretField,
-	batonClientIDField,
-	batonClientSecretField,
-})
+var configuration = field.NewConfiguration(ConfigurationFields, FieldRelationships...)
 
 var version = "dev"
 
@@ -67,12 +53,28 @@ func getConnector(ctx context.Context, v *viper.Viper) (types.ConnectorServer, e
 		return nil, err
 	}
 
-	keycloakServerURL := v.GetString(apiUrlField.FieldName)
-	keycloakRealm := v.GetString(realmField.FieldName)
-	keycloakClientID := v.GetString(keycloakclientField.FieldName)
-	keycloakClientSecret := v.GetString(keycloakclientSecretField.FieldName)
-
-	cb, err := connectorSchema.New(ctx, keycloakServerURL, keycloakRealm, keycloakClientID, keycloakClientSecret)
+	cb, err := connectorSchema.New(ctx, connectorSchema.Config{
+		ServerURL:                 getString(v, apiUrlField, keycloakServerURLField),
+		Realms:                    strings.Split(getString(v, realmField, keycloakRealmField), ","),
+		AuthRealm:                 v.GetString(authRealmField.FieldName),
+		ClientID:                  getString(v, keycloakclientField, keycloakClientIDField),
+		ClientSecret:              getString(v, keycloakclientSecretField, keycloakClientSecretField),
+		AuthMethod:                v.GetString(authMethodField.FieldName),
+		PrivateKeyPath:            v.GetString(privateKeyPathField.FieldName),
+		ClientCertificatePath:     v.GetString(clientCertificatePathField.FieldName),
+		ClientKeyPath:             v.GetString(clientKeyPathField.FieldName),
+		Username:                  v.GetString(usernameField.FieldName),
+		Password:                  v.GetString(passwordField.FieldName),
+		DirectGroupMembershipOnly: v.GetBool(directGroupMembershipOnlyField.FieldName),
+		LegacyUserIDs:             v.GetBool(legacyUserIDsField.FieldName),
+		DisableUsersOnDelete:      v.GetBool(disableUsersOnDeleteField.FieldName),
+		MaxAttempts:               v.GetInt(maxAttemptsField.FieldName),
+		PageSize:                  v.GetInt(pageSizeField.FieldName),
+		CABundlePath:              v.GetString(caBundlePathField.FieldName),
+		InsecureSkipVerify:        v.GetBool(insecureSkipVerifyField.FieldName),
+		ProxyURL:                  v.GetString(proxyURLField.FieldName),
+		RequestTimeout:            time.Duration(v.GetInt(requestTimeoutField.FieldName)) * time.Second,
+	})
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
@@ -0,0 +1,109 @@
+package connector
+
+import (
+	"context"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
+)
+
+// clientBuilder syncs Keycloak clients (OIDC/SAML applications). Clients carry no
+// entitlements of their own, they only act as the parent of their client roles.
+type clientBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+}
+
+func (o *clientBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return clientResourceType
+}
+
+func (o *clientBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var resources []*v2.Resource
+	ctx, rateLimit := keycloak.WithRateLimitRecorder(ctx)
+
+	// Clients are listed underneath the realm they are defined in.
+	if parentResourceID == nil || parentResourceID.ResourceType != realmResourceType.Id {
+		return nil, "", nil, nil
+	}
+	realm := parentResourceID.Resource
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	page, err := utils.ParseToken(pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	clients, hasMore, err := o.client.client.GetClients(ctx, realm, page.Offset)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	nextToken, err := page.Next(len(clients), hasMore)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, client := range clients {
+		clientResource, err := parseIntoClientResource(client, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		// Client roles are listed underneath their client and need to know its realm.
+		o.client.rememberRealm(clientResource.Id, realm)
+		resources = append(resources, clientResource)
+	}
+
+	return resources, nextToken, rateLimit.Annotations(), nil
+}
+
+func (o *clientBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func (o *clientBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func parseIntoClientResource(client *gocloak.Client, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"client_id":   safeString(client.ClientID),
+		"name":        safeString(client.Name),
+		"description": safeString(client.Description),
+		"protocol":    safeString(client.Protocol),
+		"enabled":     client.Enabled != nil && *client.Enabled,
+	}
+
+	appTraits := []resource.AppTraitOption{
+		resource.WithAppProfile(profile),
+	}
+
+	ret, err := resource.NewAppResource(
+		safeString(client.ClientID),
+		clientResourceType,
+		*client.ID,
+		appTraits,
+		resource.WithParentResourceID(parentResourceID),
+		resource.WithAnnotation(&v2.ChildResourceType{ResourceTypeId: clientRoleResourceType.Id}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func newClientBuilder(client *Connector) *clientBuilder {
+	return &clientBuilder{
+		resourceType: clientResourceType,
+		client:       client,
+	}
+}
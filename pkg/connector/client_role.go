@@ -0,0 +1,335 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// clientRoleBuilder syncs the roles defined on a Keycloak client. Client roles are
+// child resources of the client they belong to.
+type clientRoleBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+}
+
+func (o *clientRoleBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return clientRoleResourceType
+}
+
+func (o *clientRoleBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var resources []*v2.Resource
+	ctx, rateLimit := keycloak.WithRateLimitRecorder(ctx)
+
+	// Client roles are only listed underneath their client.
+	if parentResourceID == nil || parentResourceID.ResourceType != clientResourceType.Id {
+		return nil, "", nil, nil
+	}
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	realm, err := o.client.realmOfID(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	page, err := utils.ParseToken(pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	roles, hasMore, err := o.client.client.GetClientRoles(ctx, realm, parentResourceID.Resource, page.Offset)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	nextToken, err := page.Next(len(roles), hasMore)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, role := range roles {
+		roleResource, err := parseIntoClientRoleResource(role, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		resources = append(resources, roleResource)
+	}
+
+	return resources, nextToken, rateLimit.Annotations(), nil
+}
+
+func (o *clientRoleBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	if err := o.client.ensureConnected(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	realm, err := o.client.realmOf(ctx, resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	clientID, err := o.clientIDOfRole(ctx, realm, resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return []*v2.Entitlement{clientRoleAssignmentEntitlement(resource, clientID)}, "", nil, nil
+}
+
+// Grants returns the grants of the client role's assignment entitlement, as described by roleGrants.
+func (o *clientRoleBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	ctx, rateLimit := keycloak.WithRateLimitRecorder(ctx)
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	realm, err := o.client.realmOf(ctx, resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	clientID, err := o.clientIDOfRole(ctx, realm, resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	roleName := resource.DisplayName
+	grants, nextToken, err := o.client.roleGrants(ctx, realm, resource, clientRoleAssignmentEntitlement(resource, clientID), pToken, roleMembers{
+		users: func(ctx context.Context, first int) ([]*gocloak.User, bool, error) {
+			return o.client.client.GetClientRoleUsers(ctx, realm, clientID, roleName, first)
+		},
+		groups: func(ctx context.Context, first int) ([]*gocloak.Group, bool, error) {
+			return o.client.client.GetClientRoleGroups(ctx, realm, clientID, roleName, first)
+		},
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return grants, nextToken, rateLimit.Annotations(), nil
+}
+
+// Grant maps a client role onto a user or a group.
+func (o *clientRoleBuilder) Grant(ctx context.Context, resource *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+	l.Info("Starting client role Grant operation",
+		zap.String("resource_id", resource.Id.Resource),
+		zap.String("resource_type", resource.Id.ResourceType),
+		zap.String("entitlement_id", entitlement.Id),
+	)
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		l.Error("Failed to ensure connection", zap.Error(err))
+		return nil, nil, err
+	}
+
+	realm, err := o.client.realmOf(ctx, entitlement.Resource, resource)
+	if err != nil {
+		l.Error("Failed to resolve realm", zap.Error(err))
+		return nil, nil, err
+	}
+
+	clientID, role, err := o.roleFromEntitlementID(ctx, realm, entitlement.Id)
+	if err != nil {
+		l.Error("Failed to resolve client role from entitlement", zap.Error(err))
+		return nil, nil, err
+	}
+	l.Info("Resolved client role",
+		zap.String("realm", realm),
+		zap.String("client_id", clientID),
+		zap.String("role_name", safeString(role.Name)),
+	)
+
+	switch resource.Id.ResourceType {
+	case userResourceType.Id:
+		userID, err := o.client.resolveUserID(ctx, realm, resource.Id.Resource)
+		if err != nil {
+			l.Error("Failed to resolve user", zap.Error(err))
+			return nil, nil, err
+		}
+		if err := o.client.client.AddClientRoleToUser(ctx, realm, clientID, userID, role); err != nil {
+			l.Error("Failed to add client role to user", zap.Error(err))
+			return nil, nil, fmt.Errorf("failed to add client role to user: %w", err)
+		}
+	case groupResourceType.Id:
+		if err := o.client.client.AddClientRoleToGroup(ctx, realm, clientID, resource.Id.Resource, role); err != nil {
+			l.Error("Failed to add client role to group", zap.Error(err))
+			return nil, nil, fmt.Errorf("failed to add client role to group: %w", err)
+		}
+	default:
+		l.Error("Unsupported principal type", zap.String("resource_type", resource.Id.ResourceType))
+		return nil, nil, fmt.Errorf("client roles can only be granted to users or groups, got %s", resource.Id.ResourceType)
+	}
+	l.Info("Successfully added client role")
+
+	roleResource, err := parseIntoClientRoleResource(role, &v2.ResourceId{
+		ResourceType: clientResourceType.Id,
+		Resource:     clientID,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	grant := &v2.Grant{
+		Id:          fmt.Sprintf("grant:%s:%s", *role.ID, resource.Id.Resource),
+		Entitlement: clientRoleAssignmentEntitlement(roleResource, clientID),
+		Principal:   resource,
+	}
+	l.Info("Created grant", zap.String("grant_id", grant.Id))
+
+	return []*v2.Grant{grant}, nil, nil
+}
+
+// Revoke removes a client role mapping from a user or a group.
+func (o *clientRoleBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+	l.Info("Starting client role Revoke operation",
+		zap.String("grant_id", grant.Id),
+		zap.String("entitlement_id", grant.Entitlement.Id),
+	)
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		l.Error("Failed to ensure connection", zap.Error(err))
+		return nil, err
+	}
+
+	realm, err := o.client.realmOf(ctx, grant.Entitlement.Resource, grant.Principal)
+	if err != nil {
+		l.Error("Failed to resolve realm", zap.Error(err))
+		return nil, err
+	}
+
+	clientID, role, err := o.roleFromEntitlementID(ctx, realm, grant.Entitlement.Id)
+	if err != nil {
+		l.Error("Failed to resolve client role from entitlement", zap.Error(err))
+		return nil, err
+	}
+
+	principal := grant.Principal.Id
+	switch principal.ResourceType {
+	case userResourceType.Id:
+		userID, err := o.client.resolveUserID(ctx, realm, principal.Resource)
+		if err != nil {
+			l.Error("Failed to resolve user", zap.Error(err))
+			return nil, err
+		}
+		if err := o.client.client.DeleteClientRoleFromUser(ctx, realm, clientID, userID, role); err != nil {
+			l.Error("Failed to remove client role from user", zap.Error(err))
+			return nil, fmt.Errorf("failed to remove client role from user: %w", err)
+		}
+	case groupResourceType.Id:
+		if err := o.client.client.DeleteClientRoleFromGroup(ctx, realm, clientID, principal.Resource, role); err != nil {
+			l.Error("Failed to remove client role from group", zap.Error(err))
+			return nil, fmt.Errorf("failed to remove client role from group: %w", err)
+		}
+	default:
+		l.Error("Unsupported principal type", zap.String("resource_type", principal.ResourceType))
+		return nil, fmt.Errorf("client roles can only be revoked from users or groups, got %s", principal.ResourceType)
+	}
+	l.Info("Successfully removed client role")
+
+	return nil, nil
+}
+
+// roleFromEntitlementID parses an entitlement ID in the format client_role:<clientID>:<roleID>:assigned,
+// fetches the role and checks that it actually belongs to that client.
+func (o *clientRoleBuilder) roleFromEntitlementID(ctx context.Context, realm, entitlementID string) (string, *gocloak.Role, error) {
+	parts := strings.Split(entitlementID, ":")
+	if len(parts) != 4 || parts[0] != "client_role" || parts[3] != "assigned" {
+		return "", nil, fmt.Errorf("invalid entitlement ID format: %s", entitlementID)
+	}
+
+	clientID, roleID := parts[1], parts[2]
+	if clientID == "" || roleID == "" {
+		return "", nil, fmt.Errorf("client ID or role ID not found in entitlement ID")
+	}
+
+	role, err := o.client.client.GetClientRoleByID(ctx, realm, roleID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get client role %s: %w", roleID, err)
+	}
+
+	if role.ClientRole == nil || !*role.ClientRole || safeString(role.ContainerID) != clientID {
+		return "", nil, fmt.Errorf("role %s does not belong to client %s", roleID, clientID)
+	}
+
+	return clientID, role, nil
+}
+
+// clientIDOfRole returns the ID of the client a client role resource belongs to. Synced
+// resources carry it as their parent, otherwise we ask Keycloak for the role's container.
+func (o *clientRoleBuilder) clientIDOfRole(ctx context.Context, realm string, resource *v2.Resource) (string, error) {
+	if resource.ParentResourceId != nil && resource.ParentResourceId.ResourceType == clientResourceType.Id {
+		return resource.ParentResourceId.Resource, nil
+	}
+
+	role, err := o.client.client.GetClientRoleByID(ctx, realm, resource.Id.Resource)
+	if err != nil {
+		return "", fmt.Errorf("failed to get client role %s: %w", resource.Id.Resource, err)
+	}
+	if role.ContainerID == nil || *role.ContainerID == "" {
+		return "", fmt.Errorf("client role %s has no client", resource.Id.Resource)
+	}
+
+	return *role.ContainerID, nil
+}
+
+// clientRoleAssignmentEntitlement builds the "assigned" entitlement of a client role, in the
+// format client_role:<clientID>:<roleID>:assigned.
+func clientRoleAssignmentEntitlement(resource *v2.Resource, clientID string) *v2.Entitlement {
+	return &v2.Entitlement{
+		Id:          fmt.Sprintf("client_role:%s:%s:assigned", clientID, resource.Id.Resource),
+		DisplayName: fmt.Sprintf("%s client role", resource.DisplayName),
+		Description: fmt.Sprintf("Assigned the %s client role", resource.DisplayName),
+		GrantableTo: []*v2.ResourceType{userResourceType, groupResourceType},
+		Slug:        "assigned",
+		Resource:    resource,
+	}
+}
+
+func parseIntoClientRoleResource(role *gocloak.Role, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"name":        safeString(role.Name),
+		"description": safeString(role.Description),
+		"composite":   role.Composite != nil && *role.Composite,
+		"client":      safeString(role.ContainerID),
+	}
+
+	roleTraits := []resource.RoleTraitOption{
+		resource.WithRoleProfile(profile),
+	}
+
+	ret, err := resource.NewRoleResource(
+		safeString(role.Name),
+		clientRoleResourceType,
+		*role.ID,
+		roleTraits,
+		resource.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func newClientRoleBuilder(client *Connector) *clientRoleBuilder {
+	return &clientRoleBuilder{
+		resourceType: clientRoleResourceType,
+		client:       client,
+	}
+}
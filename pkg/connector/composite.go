@@ -0,0 +1,111 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+)
+
+// compositeRoleCacheTTL bounds how long the composite role index is reused, so a long running
+// connector picks up changes to composite roles between syncs.
+const compositeRoleCacheTTL = 5 * time.Minute
+
+// compositeRoleIndex maps a role ID to the composite roles of a realm that directly contain it.
+// Keycloak only exposes composites from the parent side, so we build the reverse index once per realm
+// and share it between the realm role and client role builders.
+type compositeRoleIndex struct {
+	mu      sync.Mutex
+	builtAt time.Time
+	parents map[string][]*gocloak.Role
+}
+
+// compositeParents returns the composite roles of a realm that directly contain the given role.
+func (c *Connector) compositeParents(ctx context.Context, realm, roleID string) ([]*gocloak.Role, error) {
+	c.compositesMu.Lock()
+	if c.composites == nil {
+		c.composites = make(map[string]*compositeRoleIndex)
+	}
+	idx, ok := c.composites[realm]
+	if !ok {
+		idx = &compositeRoleIndex{}
+		c.composites[realm] = idx
+	}
+	c.compositesMu.Unlock()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.parents == nil || time.Since(idx.builtAt) > compositeRoleCacheTTL {
+		composites, err := c.client.GetCompositeRoles(ctx, realm)
+		if err != nil {
+			return nil, err
+		}
+
+		parents := make(map[string][]*gocloak.Role)
+		for _, composite := range composites {
+			inner, err := c.client.GetRoleComposites(ctx, realm, *composite.ID)
+			if err != nil {
+				return nil, err
+			}
+			for _, role := range inner {
+				parents[*role.ID] = append(parents[*role.ID], composite)
+			}
+		}
+
+		idx.parents = parents
+		idx.builtAt = time.Now()
+	}
+
+	return idx.parents[roleID], nil
+}
+
+// compositeGrants returns a grant of the given role entitlement to every composite role containing it.
+// The grants are expandable, so everyone holding the composite role is also shown as holding this one.
+func (c *Connector) compositeGrants(ctx context.Context, realm string, resource *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, error) {
+	parents, err := c.compositeParents(ctx, realm, resource.Id.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var grants []*v2.Grant
+	for _, parent := range parents {
+		var (
+			parentResource    *v2.Resource
+			parentEntitlement *v2.Entitlement
+		)
+
+		if parent.ClientRole != nil && *parent.ClientRole {
+			clientID := safeString(parent.ContainerID)
+			parentResource, err = parseIntoClientRoleResource(parent, &v2.ResourceId{
+				ResourceType: clientResourceType.Id,
+				Resource:     clientID,
+			})
+			if err != nil {
+				return nil, err
+			}
+			parentEntitlement = clientRoleAssignmentEntitlement(parentResource, clientID)
+		} else {
+			parentResource, err = parseIntoRoleResource(parent, realmResourceID(realm))
+			if err != nil {
+				return nil, err
+			}
+			parentEntitlement = roleAssignmentEntitlement(parentResource)
+		}
+
+		grants = append(grants, &v2.Grant{
+			Id:          fmt.Sprintf("grant:%s:%s", resource.Id.Resource, *parent.ID),
+			Entitlement: entitlement,
+			Principal:   parentResource,
+			Annotations: annotations.New(&v2.GrantExpandable{
+				EntitlementIds: []string{parentEntitlement.Id},
+			}),
+		})
+	}
+
+	return grants, nil
+}
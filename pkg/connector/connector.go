@@ -2,8 +2,16 @@ package connector
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
+	"github.com/Nerzal/gocloak/v13"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
@@ -12,19 +20,75 @@ import (
 	"go.uber.org/zap"
 )
 
+// Config holds the settings the connector is created with.
+type Config struct {
+	ServerURL string
+	// Realms are the realms to sync, or "*" for every realm the client can see.
+	Realms []string
+	// AuthRealm is the realm the client credentials belong to. It is required when syncing several
+	// realms and defaults to the synced realm otherwise.
+	AuthRealm    string
+	ClientID     string
+	ClientSecret string
+	// AuthMethod is how the connector authenticates: client_secret (the default), private_key_jwt,
+	// mtls or password.
+	AuthMethod string
+	// PrivateKeyPath is the PEM encoded private key signing client assertions for private_key_jwt.
+	PrivateKeyPath string
+	// ClientCertificatePath and ClientKeyPath are the PEM encoded client certificate and key for mtls.
+	ClientCertificatePath string
+	ClientKeyPath         string
+	// Username and Password are the admin user's credentials for the password method.
+	Username string
+	Password string
+	// DirectGroupMembershipOnly disables expanding subgroup members into the membership of their parent groups.
+	DirectGroupMembershipOnly bool
+	// LegacyUserIDs accepts the username-based user IDs of older connector versions in Grant and Revoke requests.
+	LegacyUserIDs bool
+	// DisableUsersOnDelete disables users and logs out their sessions instead of deleting them.
+	DisableUsersOnDelete bool
+	// MaxAttempts is how often a request is sent before a 429 or 503 response fails it.
+	MaxAttempts int
+	// PageSize is the number of users, groups, roles or clients requested per page.
+	PageSize int
+	// CABundlePath is a PEM file of additional CA certificates to trust, such as an internal CA.
+	CABundlePath string
+	// InsecureSkipVerify disables TLS certificate verification, for lab environments only.
+	InsecureSkipVerify bool
+	// ProxyURL is the HTTP or HTTPS proxy to reach Keycloak through, overriding the environment.
+	ProxyURL string
+	// RequestTimeout bounds how long a single request to Keycloak may take. Zero means no limit.
+	RequestTimeout time.Duration
+}
+
 type Connector struct {
-	client       *keycloak.Client
-	serverURL    string
-	realm        string
-	clientID     string
-	clientSecret string
+	client                    *keycloak.Client
+	serverURL                 string
+	realms                    []string
+	allRealms                 bool
+	authRealm                 string
+	clientID                  string
+	clientSecret              string
+	clientOptions             []keycloak.Option
+	directGroupMembershipOnly bool
+	legacyUserIDs             bool
+	disableUsersOnDelete      bool
+	realmCache                sync.Map
+	bruteForceSettings        sync.Map
+
+	compositesMu sync.Mutex
+	composites   map[string]*compositeRoleIndex
 }
 
 // ResourceSyncers returns ResourceSyncer for each resource type that should be synced from the upstream service.
 func (c *Connector) ResourceSyncers(ctx context.Context) []connectorbuilder.ResourceSyncer {
 	return []connectorbuilder.ResourceSyncer{
+		newRealmBuilder(c),
 		newUserBuilder(c),
 		newGroupBuilder(c),
+		newRoleBuilder(c),
+		newClientBuilder(c),
+		newClientRoleBuilder(c),
 	}
 }
 
@@ -37,8 +101,9 @@ func (c *Connector) Asset(ctx context.Context, asset *v2.AssetRef) (string, io.R
 // Metadata returns metadata about the connector for C1 in the logs and whatnot. It will also display in the UI. Sadly emojis are not supported.
 func (c *Connector) Metadata(ctx context.Context) (*v2.ConnectorMetadata, error) {
 	return &v2.ConnectorMetadata{
-		DisplayName: "Keycloak",
-		Description: "Connector syncing users and groups from Keycloak",
+		DisplayName:           "Keycloak",
+		Description:           "Connector syncing realms, users, groups, realm roles, clients and client roles from Keycloak",
+		AccountCreationSchema: accountCreationSchema,
 	}, nil
 }
 
@@ -58,26 +123,163 @@ func (c *Connector) Close() error {
 // ensureConnected checks if the Keycloak client is connected and reconnects if necessary
 func (c *Connector) ensureConnected(ctx context.Context) error {
 	if c.client == nil {
-		c.client = keycloak.NewClient(c.serverURL, c.realm, c.clientID, c.clientSecret)
+		c.client = keycloak.NewClient(c.serverURL, c.authRealm, c.clientID, c.clientSecret, c.clientOptions...)
 	}
 
 	return c.client.Connect(ctx)
 }
 
+// resolveUserID turns a user resource ID into a Keycloak user ID. User resources are identified by
+// their Keycloak ID; with legacy user IDs enabled, IDs that don't match a user are treated as the
+// username-based IDs older versions of the connector emitted.
+func (c *Connector) resolveUserID(ctx context.Context, realm, resourceID string) (string, error) {
+	if resourceID == "" {
+		return "", fmt.Errorf("user ID not found in resource")
+	}
+
+	if !c.legacyUserIDs {
+		return resourceID, nil
+	}
+
+	_, err := c.client.GetUserByID(ctx, realm, resourceID)
+	if err == nil {
+		return resourceID, nil
+	}
+	var apiErr *gocloak.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return "", fmt.Errorf("failed to get user %s: %w", resourceID, err)
+	}
+
+	users, err := c.client.GetUsersByUsername(ctx, realm, resourceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to search users: %w", err)
+	}
+	if len(users) == 0 {
+		return "", fmt.Errorf("user not found: %s", resourceID)
+	}
+
+	return *users[0].ID, nil
+}
+
+// newAuthOption returns the client option for the configured authentication method, checking that
+// the settings it needs are present. The client secret method needs no option.
+func newAuthOption(cfg Config) (keycloak.Option, error) {
+	switch method := keycloak.AuthMethod(cfg.AuthMethod); method {
+	case "", keycloak.AuthClientSecret:
+		if cfg.ClientSecret == "" {
+			return nil, fmt.Errorf("a client secret is required for the %s auth method", keycloak.AuthClientSecret)
+		}
+		return nil, nil
+	case keycloak.AuthPrivateKeyJWT:
+		if cfg.PrivateKeyPath == "" {
+			return nil, fmt.Errorf("a private key path is required for the %s auth method", method)
+		}
+		key, signingMethod, err := keycloak.LoadPrivateKey(cfg.PrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		return keycloak.WithPrivateKeyJWT(key, signingMethod), nil
+	case keycloak.AuthMTLS:
+		if cfg.ClientCertificatePath == "" || cfg.ClientKeyPath == "" {
+			return nil, fmt.Errorf("a client certificate and key are required for the %s auth method", method)
+		}
+		certificate, err := tls.LoadX509KeyPair(cfg.ClientCertificatePath, cfg.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		return keycloak.WithClientCertificate(certificate), nil
+	case keycloak.AuthPassword:
+		if cfg.Username == "" || cfg.Password == "" {
+			return nil, fmt.Errorf("a username and password are required for the %s auth method", method)
+		}
+		return keycloak.WithPassword(cfg.Username, cfg.Password), nil
+	default:
+		return nil, fmt.Errorf("unsupported auth method %q", cfg.AuthMethod)
+	}
+}
+
 // Actually create a Keycloak connector.
-func New(ctx context.Context, keycloakServerURL string, keycloakRealm string, keycloakClientID string, keycloakClientSecret string) (*Connector, error) {
+func New(ctx context.Context, cfg Config) (*Connector, error) {
 	l := ctxzap.Extract(ctx)
-	keycloakClient := keycloak.NewClient(keycloakServerURL, keycloakRealm, keycloakClientID, keycloakClientSecret)
+
+	realms, syncAll, err := ParseRealms(cfg.Realms)
+	if err != nil {
+		return nil, err
+	}
+
+	authRealm := cfg.AuthRealm
+	if authRealm == "" {
+		if syncAll || len(realms) > 1 {
+			return nil, fmt.Errorf("an auth realm is required when syncing more than one realm")
+		}
+		authRealm = realms[0]
+	}
+
+	if cfg.MaxAttempts < 0 {
+		return nil, fmt.Errorf("max attempts must not be negative, got %d", cfg.MaxAttempts)
+	}
+	if cfg.PageSize != 0 && (cfg.PageSize < keycloak.MinPageSize || cfg.PageSize > keycloak.MaxPageSize) {
+		return nil, fmt.Errorf("page size must be between %d and %d, got %d", keycloak.MinPageSize, keycloak.MaxPageSize, cfg.PageSize)
+	}
+
+	var clientOptions []keycloak.Option
+	authOption, err := newAuthOption(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if authOption != nil {
+		clientOptions = append(clientOptions, authOption)
+	}
+	if cfg.MaxAttempts > 0 {
+		clientOptions = append(clientOptions, keycloak.WithMaxAttempts(cfg.MaxAttempts))
+	}
+	if cfg.PageSize != 0 {
+		clientOptions = append(clientOptions, keycloak.WithPageSize(cfg.PageSize))
+	}
+	if cfg.CABundlePath != "" {
+		rootCAs, err := keycloak.LoadCABundle(cfg.CABundlePath)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions = append(clientOptions, keycloak.WithRootCAs(rootCAs))
+	}
+	if cfg.InsecureSkipVerify {
+		l.Warn("TLS CERTIFICATE VERIFICATION IS DISABLED: the connection to Keycloak can be intercepted and the admin credentials stolen. Never use insecure_skip_verify outside of a lab environment.",
+			zap.String("server_url", cfg.ServerURL),
+		)
+		clientOptions = append(clientOptions, keycloak.WithInsecureSkipVerify())
+	}
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil || (proxyURL.Scheme != "http" && proxyURL.Scheme != "https") || proxyURL.Host == "" {
+			return nil, fmt.Errorf("proxy URL must be an http or https URL, got %q", cfg.ProxyURL)
+		}
+		clientOptions = append(clientOptions, keycloak.WithProxy(cfg.ProxyURL))
+	}
+	if cfg.RequestTimeout < 0 {
+		return nil, fmt.Errorf("request timeout must not be negative, got %s", cfg.RequestTimeout)
+	}
+	if cfg.RequestTimeout > 0 {
+		clientOptions = append(clientOptions, keycloak.WithTimeout(cfg.RequestTimeout))
+	}
+
+	keycloakClient := keycloak.NewClient(cfg.ServerURL, authRealm, cfg.ClientID, cfg.ClientSecret, clientOptions...)
 	if err := keycloakClient.Connect(ctx); err != nil {
 		l.Error("error creating Keycloak client for some reason", zap.Error(err))
 		return nil, err
 	}
 
 	return &Connector{
-		client:       keycloakClient,
-		serverURL:    keycloakServerURL,
-		realm:        keycloakRealm,
-		clientID:     keycloakClientID,
-		clientSecret: keycloakClientSecret,
+		client:                    keycloakClient,
+		serverURL:                 cfg.ServerURL,
+		realms:                    realms,
+		allRealms:                 syncAll,
+		authRealm:                 authRealm,
+		clientID:                  cfg.ClientID,
+		clientSecret:              cfg.ClientSecret,
+		clientOptions:             clientOptions,
+		directGroupMembershipOnly: cfg.DirectGroupMembershipOnly,
+		legacyUserIDs:             cfg.LegacyUserIDs,
+		disableUsersOnDelete:      cfg.DisableUsersOnDelete,
 	}, nil
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	"github.com/conductorone/baton-sdk/pkg/types/resource"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
 	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
 	"go.uber.org/zap"
 )
@@ -26,26 +27,59 @@ func (o *groupBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 
 func (o *groupBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
 	var resources []*v2.Resource
-	annos := annotations.Annotations{}
+	ctx, rateLimit := keycloak.WithRateLimitRecorder(ctx)
+
+	// Top level groups are listed underneath their realm, subgroups underneath the group that contains them.
+	if parentResourceID == nil {
+		return nil, "", nil, nil
+	}
 
 	if err := o.client.ensureConnected(ctx); err != nil {
 		return nil, "", nil, err
 	}
 
-	groups, nextToken, err := o.client.client.GetGroups(ctx, utils.ParseToken(pToken))
+	realm, err := o.client.realmOfID(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	page, err := utils.ParseToken(pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var (
+		groups  []*gocloak.Group
+		hasMore bool
+	)
+	switch parentResourceID.ResourceType {
+	case realmResourceType.Id:
+		groups, hasMore, err = o.client.client.GetGroups(ctx, realm, page.Offset)
+	case groupResourceType.Id:
+		groups, hasMore, err = o.client.client.GetChildGroups(ctx, realm, parentResourceID.Resource, page.Offset)
+	default:
+		return nil, "", nil, nil
+	}
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	nextToken, err := page.Next(len(groups), hasMore)
 	if err != nil {
 		return nil, "", nil, err
 	}
 
 	for _, group := range groups {
-		groupResource, err := parseIntoGroupResource(group, nil)
+		groupResource, err := parseIntoGroupResource(group, parentResourceID)
 		if err != nil {
 			return nil, "", nil, err
 		}
+		// Subgroups are listed underneath this group and need to know its realm.
+		o.client.rememberRealm(groupResource.Id, realm)
 		resources = append(resources, groupResource)
 	}
 
-	return resources, nextToken, annos, nil
+	return resources, nextToken, rateLimit.Annotations(), nil
 }
 
 func (o *groupBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
@@ -56,29 +90,45 @@ func (o *groupBuilder) Entitlements(ctx context.Context, resource *v2.Resource,
 	}
 
 	// Create a membership entitlement for the group
-	membershipEntitlement := &v2.Entitlement{
-		Id:          fmt.Sprintf("group:%s:membership", resource.Id.Resource),
-		DisplayName: fmt.Sprintf("Membership in %s", resource.DisplayName),
-		Description: fmt.Sprintf("Membership in the %s group", resource.DisplayName),
-		GrantableTo: []*v2.ResourceType{userResourceType},
-		Slug:        "membership",
-		Resource:    resource,
-	}
-
-	entitlements = append(entitlements, membershipEntitlement)
+	entitlements = append(entitlements, groupMembershipEntitlement(resource))
 	return entitlements, "", nil, nil
 }
 
 func (o *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
 	var grants []*v2.Grant
-	annos := annotations.Annotations{}
+	ctx, rateLimit := keycloak.WithRateLimitRecorder(ctx)
 
 	if err := o.client.ensureConnected(ctx); err != nil {
 		return nil, "", nil, err
 	}
 
-	// Get all users in this group directly
-	users, err := o.client.client.GetGroupMembers(ctx, resource.Id.Resource)
+	realm, err := o.client.realmOf(ctx, resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	page, err := utils.ParseToken(pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Members of a subgroup inherit the membership (and role mappings) of its parent group.
+	// They are emitted once, alongside the first page of members.
+	if page.Offset == 0 && !o.client.directGroupMembershipOnly {
+		subgroupGrants, err := o.subgroupGrants(ctx, realm, resource)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		grants = append(grants, subgroupGrants...)
+	}
+
+	// Get a page of the users in this group directly
+	users, hasMore, err := o.client.client.GetGroupMembers(ctx, realm, resource.Id.Resource, page.Offset)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	nextToken, err := page.Next(len(users), hasMore)
 	if err != nil {
 		return nil, "", nil, err
 	}
@@ -97,22 +147,49 @@ func (o *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 		userResource := userResources[*user.ID]
 
 		grant := &v2.Grant{
-			Id: fmt.Sprintf("grant:%s:%s", resource.Id.Resource, *user.ID),
-			Entitlement: &v2.Entitlement{
-				Id:          fmt.Sprintf("group:%s:membership", resource.Id.Resource),
-				DisplayName: fmt.Sprintf("Membership in %s", resource.DisplayName),
-				Description: fmt.Sprintf("Membership in the %s group", resource.DisplayName),
-				GrantableTo: []*v2.ResourceType{userResourceType},
-				Slug:        "membership",
-				Resource:    resource,
-			},
-			Principal: userResource,
+			Id:          fmt.Sprintf("grant:%s:%s", resource.Id.Resource, *user.ID),
+			Entitlement: groupMembershipEntitlement(resource),
+			Principal:   userResource,
 		}
 
 		grants = append(grants, grant)
 	}
 
-	return grants, "", annos, nil
+	return grants, nextToken, rateLimit.Annotations(), nil
+}
+
+// subgroupGrants grants the membership of a group to each of its direct subgroups, expanded through
+// the subgroup's own membership so nested members end up as effective members of every ancestor.
+func (o *groupBuilder) subgroupGrants(ctx context.Context, realm string, resource *v2.Resource) ([]*v2.Grant, error) {
+	var grants []*v2.Grant
+
+	for first := 0; ; {
+		subgroups, hasMore, err := o.client.client.GetChildGroups(ctx, realm, resource.Id.Resource, first)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, subgroup := range subgroups {
+			subgroupResource, err := parseIntoGroupResource(subgroup, resource.Id)
+			if err != nil {
+				return nil, err
+			}
+
+			grants = append(grants, &v2.Grant{
+				Id:          fmt.Sprintf("grant:%s:%s", resource.Id.Resource, *subgroup.ID),
+				Entitlement: groupMembershipEntitlement(resource),
+				Principal:   subgroupResource,
+				Annotations: annotations.New(&v2.GrantExpandable{
+					EntitlementIds: []string{groupMembershipEntitlement(subgroupResource).Id},
+				}),
+			})
+		}
+
+		if !hasMore {
+			return grants, nil
+		}
+		first += len(subgroups)
+	}
 }
 
 func (o *groupBuilder) Grant(ctx context.Context, resource *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
@@ -128,6 +205,12 @@ func (o *groupBuilder) Grant(ctx context.Context, resource *v2.Resource, entitle
 		return nil, nil, err
 	}
 
+	// Subgroup membership is synced but can't be provisioned.
+	if resource.Id.ResourceType != userResourceType.Id {
+		l.Error("Group membership can only be granted to users", zap.String("resource_type", resource.Id.ResourceType))
+		return nil, nil, fmt.Errorf("group membership can only be granted to users, got %s", resource.Id.ResourceType)
+	}
+
 	// The entitlement ID should be in the format: group:<groupID>:membership
 	parts := strings.Split(entitlement.Id, ":")
 	l.Info("Split entitlement ID parts", zap.Strings("parts", parts))
@@ -144,53 +227,41 @@ func (o *groupBuilder) Grant(ctx context.Context, resource *v2.Resource, entitle
 	}
 	l.Info("Extracted group ID", zap.String("group_id", groupID))
 
-	// Get the username from the resource
-	username := resource.Id.Resource
-	if username == "" {
-		l.Error("Username not found in resource")
-		return nil, nil, fmt.Errorf("username not found in resource")
+	realm, err := o.client.realmOf(ctx, entitlement.Resource, resource)
+	if err != nil {
+		l.Error("Failed to resolve realm", zap.Error(err))
+		return nil, nil, err
 	}
-	l.Info("Extracted username", zap.String("username", username))
+	l.Info("Resolved realm", zap.String("realm", realm))
 
-	// Verify the user exists
-	l.Info("Searching users to verify user exists")
-	users, err := o.client.client.GetUsersByUsername(ctx, username)
+	userID, err := o.client.resolveUserID(ctx, realm, resource.Id.Resource)
 	if err != nil {
-		l.Error("Failed to get users", zap.Error(err))
-		return nil, nil, fmt.Errorf("failed to search users: %w", err)
-	}
-	if len(users) == 0 {
-		l.Error("User not found in Keycloak", zap.String("username", username))
-		return nil, nil, fmt.Errorf("user not found: %s", username)
+		l.Error("Failed to resolve user", zap.Error(err))
+		return nil, nil, err
 	}
-	l.Info("Found total users", zap.Int("count", len(users)))
-
-	userID := *users[0].ID
+	l.Info("Resolved user ID", zap.String("user_id", userID))
 
 	// Add user to group
 	l.Info("Attempting to add user to group",
-		zap.String("username", username),
 		zap.String("user_id", userID),
 		zap.String("group_id", groupID),
 	)
-	err = o.client.client.AddUserToGroup(ctx, userID, groupID)
+	err = o.client.client.AddUserToGroup(ctx, realm, userID, groupID)
 	if err != nil {
 		l.Error("Failed to add user to group", zap.Error(err))
 		return nil, nil, fmt.Errorf("failed to add user to group: %w", err)
 	}
 	l.Info("Successfully added user to group")
 
+	groupResource := entitlement.Resource
+	if groupResource == nil || groupResource.Id == nil {
+		groupResource = &v2.Resource{Id: &v2.ResourceId{ResourceType: groupResourceType.Id, Resource: groupID}}
+	}
+
 	// Create and return the grant
 	grant := &v2.Grant{
-		Id: fmt.Sprintf("grant:%s:%s", groupID, userID),
-		Entitlement: &v2.Entitlement{
-			Id:          fmt.Sprintf("group:%s:membership", groupID),
-			DisplayName: fmt.Sprintf("Membership in %s", resource.DisplayName),
-			Description: fmt.Sprintf("Membership in the %s group", resource.DisplayName),
-			GrantableTo: []*v2.ResourceType{userResourceType},
-			Slug:        "membership",
-			Resource:    resource,
-		},
+		Id:          fmt.Sprintf("grant:%s:%s", groupID, userID),
+		Entitlement: groupMembershipEntitlement(groupResource),
 		Principal: &v2.Resource{
 			Id: &v2.ResourceId{
 				ResourceType: userResourceType.Id,
@@ -215,6 +286,11 @@ func (o *groupBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations
 		return nil, err
 	}
 
+	if grant.Principal.Id.ResourceType != userResourceType.Id {
+		l.Error("Group membership can only be revoked from users", zap.String("resource_type", grant.Principal.Id.ResourceType))
+		return nil, fmt.Errorf("group membership can only be revoked from users, got %s", grant.Principal.Id.ResourceType)
+	}
+
 	// Extract group ID from the entitlement ID
 	parts := strings.Split(grant.Entitlement.Id, ":")
 	if len(parts) != 3 || parts[0] != "group" || parts[2] != "membership" {
@@ -229,36 +305,26 @@ func (o *groupBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations
 	}
 	l.Info("Extracted group ID", zap.String("group_id", groupID))
 
-	// Get the username from the principal
-	username := grant.Principal.Id.Resource
-	if username == "" {
-		l.Error("Username not found in principal")
-		return nil, fmt.Errorf("username not found in principal")
+	realm, err := o.client.realmOf(ctx, grant.Entitlement.Resource, grant.Principal)
+	if err != nil {
+		l.Error("Failed to resolve realm", zap.Error(err))
+		return nil, err
 	}
-	l.Info("Extracted username", zap.String("username", username))
+	l.Info("Resolved realm", zap.String("realm", realm))
 
-	// Verify the user exists
-	l.Info("Searching users to verify user exists")
-	users, err := o.client.client.GetUsersByUsername(ctx, username)
+	userID, err := o.client.resolveUserID(ctx, realm, grant.Principal.Id.Resource)
 	if err != nil {
-		l.Error("Failed to get users", zap.Error(err))
-		return nil, fmt.Errorf("failed to search users: %w", err)
-	}
-	if len(users) == 0 {
-		l.Error("User not found in Keycloak", zap.String("username", username))
-		return nil, fmt.Errorf("user not found: %s", username)
+		l.Error("Failed to resolve user", zap.Error(err))
+		return nil, err
 	}
-	l.Info("Found total users", zap.Int("count", len(users)))
-
-	userID := *users[0].ID
+	l.Info("Resolved user ID", zap.String("user_id", userID))
 
 	// Remove user from group
 	l.Info("Attempting to remove user from group",
-		zap.String("username", username),
 		zap.String("user_id", userID),
 		zap.String("group_id", groupID),
 	)
-	err = o.client.client.RemoveUserFromGroup(ctx, userID, groupID)
+	err = o.client.client.RemoveUserFromGroup(ctx, realm, userID, groupID)
 	if err != nil {
 		l.Error("Failed to remove user from group", zap.Error(err))
 		return nil, fmt.Errorf("failed to remove user from group: %w", err)
@@ -268,6 +334,146 @@ func (o *groupBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations
 	return nil, nil
 }
 
+// Create creates a Keycloak group, as a subgroup when a parent group is given either as the
+// parent resource or as a "parent_path" in the group profile. The realm is taken from the parent
+// resource or a "realm" in the profile. Every other string in the profile, such as "description",
+// is stored as a group attribute.
+func (o *groupBuilder) Create(ctx context.Context, group *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	name := group.DisplayName
+	parentPath := ""
+	realm := ""
+	attributes := map[string][]string{}
+
+	if groupTrait, err := resource.GetGroupTrait(group); err == nil {
+		for key, value := range groupTrait.GetProfile().GetFields() {
+			str := value.GetStringValue()
+			if str == "" {
+				continue
+			}
+			switch key {
+			case "name":
+				name = str
+			case "parent_path":
+				parentPath = str
+			case "realm":
+				realm = str
+			case "path":
+				// Derived from the name and parent by Keycloak.
+			default:
+				attributes[key] = []string{str}
+			}
+		}
+	}
+	if name == "" {
+		return nil, nil, fmt.Errorf("group name is required")
+	}
+
+	newGroup := gocloak.Group{Name: gocloak.StringP(name)}
+	if len(attributes) > 0 {
+		newGroup.Attributes = &attributes
+	}
+
+	if group.ParentResourceId != nil {
+		var err error
+		realm, err = o.client.realmOfID(ctx, group.ParentResourceId)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if realm == "" {
+		defaultRealm, ok := o.client.defaultRealm()
+		if !ok {
+			return nil, nil, fmt.Errorf("a parent realm is required to create a group when syncing more than one realm")
+		}
+		realm = defaultRealm
+	}
+
+	parentID := ""
+	if group.ParentResourceId != nil && group.ParentResourceId.ResourceType == groupResourceType.Id {
+		parentID = group.ParentResourceId.Resource
+	} else if parentPath != "" {
+		parent, err := o.client.client.GetGroupByPath(ctx, realm, parentPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get parent group %s: %w", parentPath, err)
+		}
+		parentID = *parent.ID
+	}
+
+	var (
+		groupID string
+		err     error
+	)
+	if parentID != "" {
+		groupID, err = o.client.client.CreateChildGroup(ctx, realm, parentID, newGroup)
+	} else {
+		groupID, err = o.client.client.CreateGroup(ctx, realm, newGroup)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create group %s: %w", name, err)
+	}
+	l.Info("Created group",
+		zap.String("realm", realm),
+		zap.String("group_id", groupID),
+		zap.String("name", name),
+		zap.String("parent_id", parentID),
+	)
+
+	created, err := o.client.client.GetGroup(ctx, realm, groupID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get created group %s: %w", groupID, err)
+	}
+
+	parentResourceID := realmResourceID(realm)
+	if parentID != "" {
+		parentResourceID = &v2.ResourceId{ResourceType: groupResourceType.Id, Resource: parentID}
+	}
+
+	groupResource, err := parseIntoGroupResource(created, parentResourceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return groupResource, nil, nil
+}
+
+// Delete deletes a Keycloak group, along with its subgroups.
+func (o *groupBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		return nil, err
+	}
+
+	realm, err := o.client.realmOfID(ctx, resourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.client.client.DeleteGroup(ctx, realm, resourceId.Resource); err != nil {
+		return nil, fmt.Errorf("failed to delete group %s: %w", resourceId.Resource, err)
+	}
+	l.Info("Deleted group", zap.String("group_id", resourceId.Resource))
+
+	return nil, nil
+}
+
+// groupMembershipEntitlement builds the membership entitlement of a group, in the format group:<groupID>:membership.
+func groupMembershipEntitlement(resource *v2.Resource) *v2.Entitlement {
+	return &v2.Entitlement{
+		Id:          fmt.Sprintf("group:%s:membership", resource.Id.Resource),
+		DisplayName: fmt.Sprintf("Membership in %s", resource.DisplayName),
+		Description: fmt.Sprintf("Membership in the %s group", resource.DisplayName),
+		GrantableTo: []*v2.ResourceType{userResourceType},
+		Slug:        "membership",
+		Resource:    resource,
+	}
+}
+
 func parseIntoGroupResource(group *gocloak.Group, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
 	profile := map[string]interface{}{
 		"name": safeString(group.Name),
@@ -290,6 +496,7 @@ func parseIntoGroupResource(group *gocloak.Group, parentResourceID *v2.ResourceI
 		*group.ID,
 		groupTraits,
 		resource.WithParentResourceID(parentResourceID),
+		resource.WithAnnotation(&v2.ChildResourceType{ResourceTypeId: groupResourceType.Id}),
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,214 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+)
+
+const (
+	testRealm   = "test"
+	testGroupID = "all-staff"
+)
+
+// newFakeKeycloak serves a token endpoint and a single group holding memberCount users and the given
+// subgroups, paging the member listing by first and max like Keycloak does. With legacy set it behaves
+// like Keycloak 22 and earlier, which reject GET on /children and embed subgroups in the group.
+func newFakeKeycloak(t *testing.T, memberCount int, subgroups []string, legacy bool) *httptest.Server {
+	t.Helper()
+
+	members := make([]*gocloak.User, memberCount)
+	for i := range members {
+		members[i] = &gocloak.User{
+			ID:       gocloak.StringP(fmt.Sprintf("user-%d", i)),
+			Username: gocloak.StringP(fmt.Sprintf("user%d", i)),
+		}
+	}
+
+	children := make([]*gocloak.Group, len(subgroups))
+	for i, id := range subgroups {
+		children[i] = &gocloak.Group{ID: gocloak.StringP(id), Name: gocloak.StringP(id)}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/"+testRealm+"/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]interface{}{
+			"access_token": "token",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+		})
+	})
+	mux.HandleFunc("/admin/realms/"+testRealm+"/groups/"+testGroupID+"/members", func(w http.ResponseWriter, r *http.Request) {
+		first, _ := strconv.Atoi(r.URL.Query().Get("first"))
+		max, err := strconv.Atoi(r.URL.Query().Get("max"))
+		if err != nil {
+			// Without max Keycloak falls back to its default page size, truncating big groups.
+			t.Errorf("members requested without max: %s", r.URL)
+			max = 100
+		}
+		writeJSON(t, w, members[min(first, len(members)):min(first+max, len(members))])
+	})
+	mux.HandleFunc("/admin/realms/"+testRealm+"/groups/"+testGroupID+"/children", func(w http.ResponseWriter, r *http.Request) {
+		if legacy {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(t, w, children)
+	})
+	mux.HandleFunc("/admin/realms/"+testRealm+"/groups/"+testGroupID, func(w http.ResponseWriter, r *http.Request) {
+		group := gocloak.Group{ID: gocloak.StringP(testGroupID), Name: gocloak.StringP(testGroupID)}
+		if legacy {
+			subGroups := make([]gocloak.Group, len(children))
+			for i, child := range children {
+				subGroups[i] = *child
+			}
+			group.SubGroups = &subGroups
+		}
+		writeJSON(t, w, group)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	t.Helper()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("failed to write response: %v", err)
+	}
+}
+
+func newTestGroupBuilder(server *httptest.Server, pageSize int) *groupBuilder {
+	return newGroupBuilder(&Connector{
+		client: keycloak.NewClient(server.URL, testRealm, "baton", "secret", keycloak.WithPageSize(pageSize)),
+		realms: []string{testRealm},
+	})
+}
+
+func testGroupResource() *v2.Resource {
+	return &v2.Resource{
+		Id:               &v2.ResourceId{ResourceType: groupResourceType.Id, Resource: testGroupID},
+		ParentResourceId: realmResourceID(testRealm),
+		DisplayName:      "All Staff",
+	}
+}
+
+func TestGroupListFallsBackToEmbeddedSubgroups(t *testing.T) {
+	server := newFakeKeycloak(t, 0, []string{"platform", "security"}, true)
+	builder := newTestGroupBuilder(server, keycloak.DefaultPageSize)
+
+	subgroups, nextToken, _, err := builder.List(context.Background(), testGroupResource().Id, &pagination.Token{})
+	if err != nil {
+		t.Fatalf("listing subgroups: %v", err)
+	}
+	if nextToken != "" {
+		t.Errorf("got next token %q, want none", nextToken)
+	}
+
+	var ids []string
+	for _, subgroup := range subgroups {
+		ids = append(ids, subgroup.Id.Resource)
+		if parent := subgroup.ParentResourceId.GetResource(); parent != testGroupID {
+			t.Errorf("subgroup %s has parent %q, want %q", subgroup.Id.Resource, parent, testGroupID)
+		}
+	}
+	if len(ids) != 2 || ids[0] != "platform" || ids[1] != "security" {
+		t.Errorf("got subgroups %v, want [platform security]", ids)
+	}
+}
+
+func TestGroupGrantsPagesThroughAllMembers(t *testing.T) {
+	const memberCount = 2500
+
+	server := newFakeKeycloak(t, memberCount, []string{"platform", "security"}, false)
+	builder := newTestGroupBuilder(server, keycloak.MaxPageSize)
+	group := testGroupResource()
+
+	ctx := context.Background()
+	memberGrants := make(map[string]int)
+	pages := 0
+	token := ""
+	for {
+		grants, nextToken, _, err := builder.Grants(ctx, group, &pagination.Token{Token: token})
+		if err != nil {
+			t.Fatalf("page %d: %v", pages, err)
+		}
+
+		for _, grant := range grants {
+			switch grant.Principal.Id.ResourceType {
+			case userResourceType.Id:
+				memberGrants[grant.Principal.Id.Resource]++
+			case groupResourceType.Id:
+				if pages != 0 {
+					t.Errorf("page %d: unexpected subgroup grant %s, subgroups belong to the first page", pages, grant.Id)
+				}
+			default:
+				t.Errorf("page %d: unexpected principal type %s", pages, grant.Principal.Id.ResourceType)
+			}
+		}
+		if pages == 0 {
+			subgroupGrants := len(grants) - len(memberGrants)
+			if subgroupGrants != 2 {
+				t.Errorf("first page: got %d subgroup grants, want 2", subgroupGrants)
+			}
+		}
+
+		pages++
+		if nextToken == "" {
+			break
+		}
+		if pages > memberCount {
+			t.Fatalf("pager does not terminate, last token %q", nextToken)
+		}
+		token = nextToken
+	}
+
+	if len(memberGrants) != memberCount {
+		t.Errorf("got grants for %d members, want %d", len(memberGrants), memberCount)
+	}
+	for i := 0; i < memberCount; i++ {
+		id := fmt.Sprintf("user-%d", i)
+		if n := memberGrants[id]; n != 1 {
+			t.Errorf("member %s granted %d times, want once", id, n)
+		}
+	}
+	if pages != 3 {
+		t.Errorf("got %d pages, want 3", pages)
+	}
+}
+
+func TestGroupGrantsOnKeycloakWithoutChildrenEndpoint(t *testing.T) {
+	server := newFakeKeycloak(t, 3, []string{"platform", "security"}, true)
+	builder := newTestGroupBuilder(server, keycloak.DefaultPageSize)
+
+	grants, nextToken, _, err := builder.Grants(context.Background(), testGroupResource(), &pagination.Token{})
+	if err != nil {
+		t.Fatalf("listing grants: %v", err)
+	}
+	if nextToken != "" {
+		t.Errorf("got next token %q, want none", nextToken)
+	}
+
+	principals := make(map[string][]string)
+	for _, grant := range grants {
+		principals[grant.Principal.Id.ResourceType] = append(principals[grant.Principal.Id.ResourceType], grant.Principal.Id.Resource)
+	}
+	if subgroups := principals[groupResourceType.Id]; len(subgroups) != 2 || subgroups[0] != "platform" || subgroups[1] != "security" {
+		t.Errorf("got subgroup grants %v, want [platform security]", subgroups)
+	}
+	if members := principals[userResourceType.Id]; len(members) != 3 {
+		t.Errorf("got member grants %v, want 3", members)
+	}
+}
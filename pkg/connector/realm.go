@@ -0,0 +1,261 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+)
+
+// allRealms is the realm configuration value that syncs every realm the client can see.
+const allRealms = "*"
+
+// ParseRealms reads the configured realms. Entries are trimmed and empty ones skipped, so a trailing
+// comma is harmless. It reports whether every realm is synced, which can't be combined with naming
+// realms.
+func ParseRealms(entries []string) ([]string, bool, error) {
+	var realms []string
+	all := false
+	for _, realm := range entries {
+		switch realm = strings.TrimSpace(realm); realm {
+		case "":
+		case allRealms:
+			all = true
+		default:
+			realms = append(realms, realm)
+		}
+	}
+
+	if all && len(realms) > 0 {
+		return nil, false, fmt.Errorf("%s can't be combined with other realms", allRealms)
+	}
+	if !all && len(realms) == 0 {
+		return nil, false, fmt.Errorf("at least one realm is required")
+	}
+
+	return realms, all, nil
+}
+
+// realmBuilder syncs the configured Keycloak realms. Realms are the parent of the users, groups,
+// realm roles and clients defined in them.
+type realmBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+}
+
+func (o *realmBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return realmResourceType
+}
+
+func (o *realmBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var resources []*v2.Resource
+	ctx, rateLimit := keycloak.WithRateLimitRecorder(ctx)
+
+	if parentResourceID != nil {
+		return nil, "", nil, nil
+	}
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	var realms []*gocloak.RealmRepresentation
+	if o.client.allRealms {
+		var err error
+		realms, err = o.client.client.GetRealms(ctx)
+		if err != nil {
+			return nil, "", nil, err
+		}
+	} else {
+		for _, name := range o.client.realms {
+			realm, err := o.client.client.GetRealm(ctx, name)
+			if err != nil {
+				return nil, "", nil, err
+			}
+			realms = append(realms, realm)
+		}
+	}
+
+	for _, realm := range realms {
+		realmResource, err := parseIntoRealmResource(realm)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		resources = append(resources, realmResource)
+	}
+
+	return resources, "", rateLimit.Annotations(), nil
+}
+
+func (o *realmBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func (o *realmBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// realmNames returns the names of the realms being synced.
+func (c *Connector) realmNames(ctx context.Context) ([]string, error) {
+	if !c.allRealms {
+		return c.realms, nil
+	}
+
+	realms, err := c.client.GetRealms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(realms))
+	for _, realm := range realms {
+		names = append(names, safeString(realm.Realm))
+	}
+	return names, nil
+}
+
+// defaultRealm returns the realm being synced when there is exactly one.
+func (c *Connector) defaultRealm() (string, bool) {
+	if c.allRealms || len(c.realms) != 1 {
+		return "", false
+	}
+	return c.realms[0], true
+}
+
+// realmOf works out the realm the given resources live in, such as the entitlement resource and
+// principal of a grant. Resources synced directly under a realm carry it as their parent; for the
+// others see realmOfID.
+func (c *Connector) realmOf(ctx context.Context, resources ...*v2.Resource) (string, error) {
+	for _, r := range resources {
+		if r != nil && r.ParentResourceId != nil && r.ParentResourceId.ResourceType == realmResourceType.Id {
+			return r.ParentResourceId.Resource, nil
+		}
+	}
+
+	var err error
+	for _, r := range resources {
+		if r == nil || r.Id == nil {
+			continue
+		}
+		var realm string
+		if r.ParentResourceId != nil {
+			if realm, err = c.realmOfID(ctx, r.ParentResourceId); err == nil {
+				return realm, nil
+			}
+		}
+		if realm, err = c.realmOfID(ctx, r.Id); err == nil {
+			return realm, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("unable to determine the realm: no resource given")
+	}
+	return "", err
+}
+
+// realmOfID works out the realm of a resource from its ID alone. Subgroups and client roles aren't
+// direct children of their realm, so we remember the realm of every group and client we list and,
+// failing that, look the resource up in each synced realm.
+func (c *Connector) realmOfID(ctx context.Context, id *v2.ResourceId) (string, error) {
+	if id.ResourceType == realmResourceType.Id {
+		return id.Resource, nil
+	}
+	if realm, ok := c.defaultRealm(); ok {
+		return realm, nil
+	}
+	if realm, ok := c.realmCache.Load(realmCacheKey(id)); ok {
+		return realm.(string), nil
+	}
+
+	realms, err := c.realmNames(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, realm := range realms {
+		var err error
+		switch id.ResourceType {
+		case userResourceType.Id:
+			_, err = c.client.GetUserByID(ctx, realm, id.Resource)
+		case groupResourceType.Id:
+			_, err = c.client.GetGroup(ctx, realm, id.Resource)
+		case roleResourceType.Id:
+			_, err = c.client.GetRealmRoleByID(ctx, realm, id.Resource)
+		case clientResourceType.Id:
+			_, err = c.client.GetClient(ctx, realm, id.Resource)
+		case clientRoleResourceType.Id:
+			_, err = c.client.GetClientRoleByID(ctx, realm, id.Resource)
+		default:
+			return "", fmt.Errorf("unable to determine the realm of resource type %s", id.ResourceType)
+		}
+
+		var apiErr *gocloak.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to look up %s %s in realm %s: %w", id.ResourceType, id.Resource, realm, err)
+		}
+
+		c.rememberRealm(id, realm)
+		return realm, nil
+	}
+
+	return "", fmt.Errorf("%s %s not found in any synced realm", id.ResourceType, id.Resource)
+}
+
+// rememberRealm records the realm of a resource for later realmOfID calls.
+func (c *Connector) rememberRealm(id *v2.ResourceId, realm string) {
+	c.realmCache.Store(realmCacheKey(id), realm)
+}
+
+func realmCacheKey(id *v2.ResourceId) string {
+	return id.ResourceType + ":" + id.Resource
+}
+
+// realmResourceID returns the resource ID of a realm, the parent of the resources defined in it.
+func realmResourceID(realm string) *v2.ResourceId {
+	return &v2.ResourceId{
+		ResourceType: realmResourceType.Id,
+		Resource:     realm,
+	}
+}
+
+func parseIntoRealmResource(realm *gocloak.RealmRepresentation) (*v2.Resource, error) {
+	name := safeString(realm.Realm)
+	displayName := safeString(realm.DisplayName)
+	if displayName == "" {
+		displayName = name
+	}
+
+	ret, err := resource.NewResource(
+		displayName,
+		realmResourceType,
+		name,
+		resource.WithAnnotation(
+			&v2.ChildResourceType{ResourceTypeId: userResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: groupResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: roleResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: clientResourceType.Id},
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func newRealmBuilder(client *Connector) *realmBuilder {
+	return &realmBuilder{
+		resourceType: realmResourceType,
+		client:       client,
+	}
+}
@@ -5,6 +5,10 @@ import (
 )
 
 var (
+	realmResourceType = &v2.ResourceType{
+		Id:          "realm",
+		DisplayName: "Realm",
+	}
 	userResourceType = &v2.ResourceType{
 		Id:          "user",
 		DisplayName: "User",
@@ -15,4 +19,19 @@ var (
 		DisplayName: "Group",
 		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
 	}
+	roleResourceType = &v2.ResourceType{
+		Id:          "role",
+		DisplayName: "Role",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_ROLE},
+	}
+	clientResourceType = &v2.ResourceType{
+		Id:          "client",
+		DisplayName: "Client",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_APP},
+	}
+	clientRoleResourceType = &v2.ResourceType{
+		Id:          "client_role",
+		DisplayName: "Client Role",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_ROLE},
+	}
 )
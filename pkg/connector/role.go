@@ -0,0 +1,281 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type roleBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+}
+
+func (o *roleBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return roleResourceType
+}
+
+func (o *roleBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var resources []*v2.Resource
+	ctx, rateLimit := keycloak.WithRateLimitRecorder(ctx)
+
+	// Realm roles are listed underneath the realm they are defined in.
+	if parentResourceID == nil || parentResourceID.ResourceType != realmResourceType.Id {
+		return nil, "", nil, nil
+	}
+	realm := parentResourceID.Resource
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	page, err := utils.ParseToken(pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	roles, hasMore, err := o.client.client.GetRealmRoles(ctx, realm, page.Offset)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	nextToken, err := page.Next(len(roles), hasMore)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, role := range roles {
+		roleResource, err := parseIntoRoleResource(role, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		resources = append(resources, roleResource)
+	}
+
+	return resources, nextToken, rateLimit.Annotations(), nil
+}
+
+func (o *roleBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return []*v2.Entitlement{roleAssignmentEntitlement(resource)}, "", nil, nil
+}
+
+// Grants returns the grants of the realm role's assignment entitlement, as described by roleGrants.
+func (o *roleBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	ctx, rateLimit := keycloak.WithRateLimitRecorder(ctx)
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	realm, err := o.client.realmOf(ctx, resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Keycloak addresses realm role membership by role name rather than ID.
+	roleName := resource.DisplayName
+	grants, nextToken, err := o.client.roleGrants(ctx, realm, resource, roleAssignmentEntitlement(resource), pToken, roleMembers{
+		users: func(ctx context.Context, first int) ([]*gocloak.User, bool, error) {
+			return o.client.client.GetRealmRoleUsers(ctx, realm, roleName, first)
+		},
+		groups: func(ctx context.Context, first int) ([]*gocloak.Group, bool, error) {
+			return o.client.client.GetRealmRoleGroups(ctx, realm, roleName, first)
+		},
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return grants, nextToken, rateLimit.Annotations(), nil
+}
+
+func (o *roleBuilder) Grant(ctx context.Context, resource *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+	l.Info("Starting role Grant operation",
+		zap.String("resource_id", resource.Id.Resource),
+		zap.String("resource_display_name", resource.DisplayName),
+		zap.String("entitlement_id", entitlement.Id),
+	)
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		l.Error("Failed to ensure connection", zap.Error(err))
+		return nil, nil, err
+	}
+
+	if resource.Id.ResourceType != userResourceType.Id {
+		l.Error("Realm roles can only be granted to users", zap.String("resource_type", resource.Id.ResourceType))
+		return nil, nil, fmt.Errorf("realm roles can only be granted to users, got %s", resource.Id.ResourceType)
+	}
+
+	realm, err := o.client.realmOf(ctx, entitlement.Resource, resource)
+	if err != nil {
+		l.Error("Failed to resolve realm", zap.Error(err))
+		return nil, nil, err
+	}
+	l.Info("Resolved realm", zap.String("realm", realm))
+
+	role, err := o.roleFromEntitlementID(ctx, realm, entitlement.Id)
+	if err != nil {
+		l.Error("Failed to resolve role from entitlement", zap.Error(err))
+		return nil, nil, err
+	}
+
+	userID, err := o.client.resolveUserID(ctx, realm, resource.Id.Resource)
+	if err != nil {
+		l.Error("Failed to resolve user", zap.Error(err))
+		return nil, nil, err
+	}
+	l.Info("Resolved user ID", zap.String("user_id", userID))
+
+	l.Info("Attempting to add realm role to user",
+		zap.String("user_id", userID),
+		zap.String("role_name", safeString(role.Name)),
+	)
+	err = o.client.client.AddRealmRoleToUser(ctx, realm, userID, role)
+	if err != nil {
+		l.Error("Failed to add realm role to user", zap.Error(err))
+		return nil, nil, fmt.Errorf("failed to add realm role to user: %w", err)
+	}
+	l.Info("Successfully added realm role to user")
+
+	roleResource, err := parseIntoRoleResource(role, realmResourceID(realm))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	grant := &v2.Grant{
+		Id:          fmt.Sprintf("grant:%s:%s", *role.ID, userID),
+		Entitlement: roleAssignmentEntitlement(roleResource),
+		Principal:   resource,
+	}
+	l.Info("Created grant", zap.String("grant_id", grant.Id))
+
+	return []*v2.Grant{grant}, nil, nil
+}
+
+func (o *roleBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+	l.Info("Starting role Revoke operation",
+		zap.String("grant_id", grant.Id),
+		zap.String("entitlement_id", grant.Entitlement.Id),
+	)
+
+	if err := o.client.ensureConnected(ctx); err != nil {
+		l.Error("Failed to ensure connection", zap.Error(err))
+		return nil, err
+	}
+
+	if grant.Principal.Id.ResourceType != userResourceType.Id {
+		l.Error("Realm roles can only be revoked from users", zap.String("resource_type", grant.Principal.Id.ResourceType))
+		return nil, fmt.Errorf("realm roles can only be revoked from users, got %s", grant.Principal.Id.ResourceType)
+	}
+
+	realm, err := o.client.realmOf(ctx, grant.Entitlement.Resource, grant.Principal)
+	if err != nil {
+		l.Error("Failed to resolve realm", zap.Error(err))
+		return nil, err
+	}
+	l.Info("Resolved realm", zap.String("realm", realm))
+
+	role, err := o.roleFromEntitlementID(ctx, realm, grant.Entitlement.Id)
+	if err != nil {
+		l.Error("Failed to resolve role from entitlement", zap.Error(err))
+		return nil, err
+	}
+
+	userID, err := o.client.resolveUserID(ctx, realm, grant.Principal.Id.Resource)
+	if err != nil {
+		l.Error("Failed to resolve user", zap.Error(err))
+		return nil, err
+	}
+	l.Info("Resolved user ID", zap.String("user_id", userID))
+
+	l.Info("Attempting to remove realm role from user",
+		zap.String("user_id", userID),
+		zap.String("role_name", safeString(role.Name)),
+	)
+	err = o.client.client.DeleteRealmRoleFromUser(ctx, realm, userID, role)
+	if err != nil {
+		l.Error("Failed to remove realm role from user", zap.Error(err))
+		return nil, fmt.Errorf("failed to remove realm role from user: %w", err)
+	}
+	l.Info("Successfully removed realm role from user")
+
+	return nil, nil
+}
+
+// roleFromEntitlementID parses an entitlement ID in the format role:<roleID>:assigned and fetches the role it refers to.
+func (o *roleBuilder) roleFromEntitlementID(ctx context.Context, realm, entitlementID string) (*gocloak.Role, error) {
+	parts := strings.Split(entitlementID, ":")
+	if len(parts) != 3 || parts[0] != "role" || parts[2] != "assigned" {
+		return nil, fmt.Errorf("invalid entitlement ID format: %s", entitlementID)
+	}
+
+	roleID := parts[1]
+	if roleID == "" {
+		return nil, fmt.Errorf("role ID not found in entitlement ID")
+	}
+
+	role, err := o.client.client.GetRealmRoleByID(ctx, realm, roleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get realm role %s: %w", roleID, err)
+	}
+
+	return role, nil
+}
+
+// roleAssignmentEntitlement builds the "assigned" entitlement of a realm role, in the format role:<roleID>:assigned.
+func roleAssignmentEntitlement(resource *v2.Resource) *v2.Entitlement {
+	return &v2.Entitlement{
+		Id:          fmt.Sprintf("role:%s:assigned", resource.Id.Resource),
+		DisplayName: fmt.Sprintf("%s role", resource.DisplayName),
+		Description: fmt.Sprintf("Assigned the %s realm role", resource.DisplayName),
+		// Only users can be assigned realm roles through Grant. Groups and composite roles holding
+		// the role are synced as expandable grants.
+		GrantableTo: []*v2.ResourceType{userResourceType},
+		Slug:        "assigned",
+		Resource:    resource,
+	}
+}
+
+func parseIntoRoleResource(role *gocloak.Role, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"name":        safeString(role.Name),
+		"description": safeString(role.Description),
+		"composite":   role.Composite != nil && *role.Composite,
+	}
+
+	roleTraits := []resource.RoleTraitOption{
+		resource.WithRoleProfile(profile),
+	}
+
+	ret, err := resource.NewRoleResource(
+		safeString(role.Name),
+		roleResourceType,
+		*role.ID,
+		roleTraits,
+		resource.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func newRoleBuilder(client *Connector) *roleBuilder {
+	return &roleBuilder{
+		resourceType: roleResourceType,
+		client:       client,
+	}
+}
@@ -0,0 +1,123 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
+)
+
+// Role grants are paged through in phases, first the users holding the role, then the groups it is
+// mapped to and finally the composite roles containing it. The page token's state records the phase.
+const (
+	roleGrantsPhase      = "phase"
+	roleGrantsUsers      = "users"
+	roleGrantsGroups     = "groups"
+	roleGrantsComposites = "composites"
+)
+
+// roleMembers fetches pages of the users and groups a realm or client role is mapped to directly.
+type roleMembers struct {
+	users  func(ctx context.Context, first int) ([]*gocloak.User, bool, error)
+	groups func(ctx context.Context, first int) ([]*gocloak.Group, bool, error)
+}
+
+// roleGrants returns a page of the grants of a role's assignment entitlement, shared by realm and
+// client roles: one for every user and group the role is mapped to directly, and one for every
+// composite role containing it.
+func (c *Connector) roleGrants(
+	ctx context.Context,
+	realm string,
+	resource *v2.Resource,
+	entitlement *v2.Entitlement,
+	pToken *pagination.Token,
+	members roleMembers,
+) ([]*v2.Grant, string, error) {
+	var grants []*v2.Grant
+
+	page, err := utils.ParseToken(pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch phase := page.State[roleGrantsPhase]; phase {
+	case "", roleGrantsUsers:
+		users, hasMore, err := members.users(ctx, page.Offset)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, user := range users {
+			userResource, err := parseIntoUserResource(user, nil)
+			if err != nil {
+				return nil, "", err
+			}
+
+			grants = append(grants, &v2.Grant{
+				Id:          fmt.Sprintf("grant:%s:%s", resource.Id.Resource, userResource.Id.Resource),
+				Entitlement: entitlement,
+				Principal:   userResource,
+			})
+		}
+
+		nextToken, err := nextRoleGrantsPage(page, len(users), hasMore, roleGrantsGroups)
+		if err != nil {
+			return nil, "", err
+		}
+		return grants, nextToken, nil
+
+	case roleGrantsGroups:
+		groups, hasMore, err := members.groups(ctx, page.Offset)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, group := range groups {
+			groupResource, err := parseIntoGroupResource(group, nil)
+			if err != nil {
+				return nil, "", err
+			}
+
+			grants = append(grants, &v2.Grant{
+				Id:          fmt.Sprintf("grant:%s:%s", resource.Id.Resource, *group.ID),
+				Entitlement: entitlement,
+				Principal:   groupResource,
+				// Members of the group inherit its role mappings.
+				Annotations: annotations.New(&v2.GrantExpandable{
+					EntitlementIds: []string{groupMembershipEntitlement(groupResource).Id},
+				}),
+			})
+		}
+
+		nextToken, err := nextRoleGrantsPage(page, len(groups), hasMore, roleGrantsComposites)
+		if err != nil {
+			return nil, "", err
+		}
+		return grants, nextToken, nil
+
+	case roleGrantsComposites:
+		// Composite roles containing this role hand it to everyone who holds them.
+		grants, err := c.compositeGrants(ctx, realm, resource, entitlement)
+		if err != nil {
+			return nil, "", err
+		}
+		return grants, "", nil
+
+	default:
+		return nil, "", fmt.Errorf("invalid page token: unknown role grants phase %q", phase)
+	}
+}
+
+// nextRoleGrantsPage returns the token for the next page of the current phase, or for the first page
+// of the next phase once the current one is exhausted.
+func nextRoleGrantsPage(page *utils.PageToken, count int, hasMore bool, nextPhase string) (string, error) {
+	if hasMore {
+		return page.Next(count, hasMore)
+	}
+
+	return (&utils.PageToken{State: map[string]string{roleGrantsPhase: nextPhase}}).Marshal()
+}
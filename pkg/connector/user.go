@@ -3,13 +3,19 @@ package connector
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/Nerzal/gocloak/v13"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/crypto"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
 	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
+	"go.uber.org/zap"
 )
 
 // userBuilder implements the resource builder interface for Keycloak user resources.
@@ -28,7 +34,7 @@ func (o *userBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 // List retrieves all user resources from Keycloak and converts them to the Baton format.
 // Parameters:
 //   - ctx: Context for cancellation and timeouts
-//   - parentResourceID: The parent resource ID (unused in this implementation)
+//   - parentResourceID: The realm the users are listed from
 //   - pToken: Pagination token for handling large result sets
 //
 // Returns:
@@ -37,145 +43,362 @@ func (o *userBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 //   - annotations.Annotations: Additional metadata
 //   - error: Any error that occurred during the operation
 func (o *userBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
 	var resource []*v2.Resource
-	annos := annotations.Annotations{}
+	ctx, rateLimit := keycloak.WithRateLimitRecorder(ctx)
+
+	// Users are listed underneath the realm they belong to.
+	if parentResourceID == nil || parentResourceID.ResourceType != realmResourceType.Id {
+		return nil, "", nil, nil
+	}
+	realm := parentResourceID.Resource
 
 	if err := o.client.ensureConnected(ctx); err != nil {
 		return nil, "", nil, err
 	}
 
-	users, nextToken, err := o.client.client.GetUsers(ctx, utils.ParseToken(pToken))
+	page, err := utils.ParseToken(pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	users, hasMore, err := o.client.client.GetUsers(ctx, realm, page.Offset)
 	if err != nil {
 		return nil, "", nil, err
 	}
 
+	nextToken, err := page.Next(len(users), hasMore)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Temporary lockouts aren't part of the user representation, so only ask for them when the realm
+	// has brute force detection turned on.
+	bruteForceProtected, err := o.client.isBruteForceProtected(ctx, realm)
+	if err != nil {
+		l.Warn("unable to read the realm's brute force detection setting, skipping lockout checks", zap.Error(err))
+	}
+
 	for _, user := range users {
-		userResource, err := parseIntoUserResource(user, nil)
+		var lockout *gocloak.BruteForceStatus
+		if bruteForceProtected {
+			lockout, err = o.client.client.GetUserBruteForceStatus(ctx, realm, *user.ID)
+			if err != nil {
+				l.Warn("unable to read the user's brute force detection status, reporting it without lockout details",
+					zap.String("user_id", *user.ID),
+					zap.Error(err),
+				)
+			}
+		}
+
+		userResource, err := parseIntoUserResourceWithLockout(user, lockout, parentResourceID)
 		if err != nil {
 			return nil, "", nil, err
 		}
 		resource = append(resource, userResource)
 	}
 
-	return resource, nextToken, annos, nil
+	return resource, nextToken, rateLimit.Annotations(), nil
 }
 
-// Entitlements returns entitlements for the user resource.
+// Entitlements returns no entitlements for users. Group membership is an entitlement of the group,
+// so it is only listed once, from the group side.
+func (o *userBuilder) Entitlements(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// Grants returns no grants for users. The group builder emits a grant of the group's membership
+// entitlement for each of its members, so users' groups aren't fetched a second time.
+func (o *userBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// CreateAccountCapabilityDetails describes the credential options supported when creating accounts.
+// A random password is set as a temporary password the user has to change on first login, without a
+// password Keycloak emails the user a link to set one themselves.
+func (o *userBuilder) CreateAccountCapabilityDetails(ctx context.Context) (*v2.CredentialDetailsAccountProvisioning, annotations.Annotations, error) {
+	return &v2.CredentialDetailsAccountProvisioning{
+		SupportedCredentialOptions: []v2.CapabilityDetailCredentialOption{
+			v2.CapabilityDetailCredentialOption_CAPABILITY_DETAIL_CREDENTIAL_OPTION_RANDOM_PASSWORD,
+			v2.CapabilityDetailCredentialOption_CAPABILITY_DETAIL_CREDENTIAL_OPTION_NO_PASSWORD,
+		},
+		PreferredCredentialOption: v2.CapabilityDetailCredentialOption_CAPABILITY_DETAIL_CREDENTIAL_OPTION_RANDOM_PASSWORD,
+	}, nil, nil
+}
+
+// CreateAccount creates a user in Keycloak from the fields of the account creation schema.
 // Parameters:
 //   - ctx: Context for cancellation and timeouts
-//   - resource: The user resource
-//   - pToken: Pagination token for handling large result sets
+//   - accountInfo: Login, emails and profile of the account to create
+//   - credentialOptions: Whether to generate a temporary password or send an execute-actions email
 //
 // Returns:
-//   - []*v2.Entitlement: List of entitlements for the user
-//   - string: Next page token for pagination
+//   - connectorbuilder.CreateAccountResponse: The created user resource
+//   - []*v2.PlaintextData: The generated temporary password, if any
 //   - annotations.Annotations: Additional metadata
 //   - error: Any error that occurred during the operation
-func (o *userBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
-	var entitlements []*v2.Entitlement
+func (o *userBuilder) CreateAccount(
+	ctx context.Context,
+	accountInfo *v2.AccountInfo,
+	credentialOptions *v2.CredentialOptions,
+) (connectorbuilder.CreateAccountResponse, []*v2.PlaintextData, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
 
 	if err := o.client.ensureConnected(ctx); err != nil {
-		return nil, "", nil, err
+		return nil, nil, nil, err
 	}
 
-	// Get the user by username to get their ID
-	users, err := o.client.client.GetUsersByUsername(ctx, resource.DisplayName)
-	if err != nil {
-		return nil, "", nil, err
+	profile := accountInfo.GetProfile()
+
+	username := accountInfo.GetLogin()
+	if username == "" {
+		username, _ = resource.GetProfileStringValue(profile, "username")
 	}
-	if len(users) == 0 {
-		return nil, "", nil, fmt.Errorf("user not found")
+	if username == "" {
+		return nil, nil, nil, fmt.Errorf("username is required to create an account")
 	}
 
-	userID := *users[0].ID
+	email, _ := resource.GetProfileStringValue(profile, "email")
+	for _, e := range accountInfo.GetEmails() {
+		if email == "" || e.GetIsPrimary() {
+			email = e.GetAddress()
+		}
+	}
 
-	// Get all groups the user is a member of
-	groups, err := o.client.client.GetUserGroups(ctx, userID)
-	if err != nil {
-		return nil, "", nil, err
+	realm, _ := resource.GetProfileStringValue(profile, "realm")
+	if realm == "" {
+		defaultRealm, ok := o.client.defaultRealm()
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("a realm is required to create an account when syncing more than one realm")
+		}
+		realm = defaultRealm
+	}
+
+	firstName, _ := resource.GetProfileStringValue(profile, "first_name")
+	lastName, _ := resource.GetProfileStringValue(profile, "last_name")
+	emailVerified := profile.GetFields()["email_verified"].GetBoolValue()
+
+	var groups []string
+	for _, group := range profile.GetFields()["groups"].GetListValue().GetValues() {
+		if path := group.GetStringValue(); path != "" {
+			groups = append(groups, path)
+		}
 	}
 
-	for _, group := range groups {
-		groupResource, err := parseIntoGroupResource(group, nil)
+	user := gocloak.User{
+		Username:      gocloak.StringP(username),
+		Enabled:       gocloak.BoolP(true),
+		EmailVerified: gocloak.BoolP(emailVerified),
+	}
+	if email != "" {
+		user.Email = gocloak.StringP(email)
+	}
+	if firstName != "" {
+		user.FirstName = gocloak.StringP(firstName)
+	}
+	if lastName != "" {
+		user.LastName = gocloak.StringP(lastName)
+	}
+	if len(groups) > 0 {
+		user.Groups = &groups
+	}
+
+	var plaintexts []*v2.PlaintextData
+	sendActionsEmail := false
+	switch {
+	case credentialOptions.GetRandomPassword() != nil:
+		password, err := crypto.GeneratePassword(credentialOptions)
 		if err != nil {
-			return nil, "", nil, err
+			return nil, nil, nil, err
+		}
+		// Setting the credential on creation means a password policy violation fails the whole request
+		// instead of leaving a user without a password behind.
+		user.Credentials = &[]gocloak.CredentialRepresentation{{
+			Type:      gocloak.StringP("password"),
+			Value:     gocloak.StringP(password),
+			Temporary: gocloak.BoolP(true),
+		}}
+		plaintexts = append(plaintexts, &v2.PlaintextData{
+			Name:        "password",
+			Description: "Temporary password, to be changed on first login",
+			Bytes:       []byte(password),
+		})
+	case credentialOptions.GetNoPassword() != nil:
+		if email == "" {
+			return nil, nil, nil, fmt.Errorf("an email address is required to create an account without a password")
 		}
+		sendActionsEmail = true
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported credential option")
+	}
+
+	userID, err := o.client.client.CreateUser(ctx, realm, user)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create user %s: %w", username, err)
+	}
+	l.Info("Created user", zap.String("realm", realm), zap.String("username", username), zap.String("user_id", userID))
 
-		// Create an entitlement for each group membership
-		membershipEntitlement := &v2.Entitlement{
-			Id:          fmt.Sprintf("user:%s:group:%s", userID, *group.ID),
-			DisplayName: fmt.Sprintf("Membership in %s", *group.Name),
-			Description: fmt.Sprintf("Membership in the %s group", *group.Name),
-			GrantableTo: []*v2.ResourceType{userResourceType},
-			Slug:        "membership",
-			Resource:    groupResource,
+	if sendActionsEmail {
+		actions := []string{"UPDATE_PASSWORD"}
+		if !emailVerified {
+			actions = append(actions, "VERIFY_EMAIL")
+		}
+		if err := o.client.client.ExecuteActionsEmail(ctx, realm, userID, actions); err != nil {
+			return nil, nil, nil, fmt.Errorf("user %s was created but sending the actions email failed: %w", username, err)
 		}
+	}
 
-		entitlements = append(entitlements, membershipEntitlement)
+	created, err := o.client.client.GetUserByID(ctx, realm, userID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get created user %s: %w", userID, err)
+	}
+
+	userResource, err := parseIntoUserResource(created, realmResourceID(realm))
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	return entitlements, "", nil, nil
+	return &v2.CreateAccountResponse_SuccessResult{
+		Resource:              userResource,
+		IsCreateAccountResult: true,
+	}, plaintexts, nil, nil
 }
 
-// Grants returns grants for the user resource.
+// Delete deprovisions a Keycloak user. By default the user is deleted outright; with
+// disable-on-delete configured the account is disabled and all of its sessions are logged out
+// instead, so the user loses access immediately while their audit trail stays in Keycloak.
 // Parameters:
 //   - ctx: Context for cancellation and timeouts
-//   - resource: The user resource
-//   - pToken: Pagination token for handling large result sets
+//   - resourceId: The ID of the user resource to deprovision
 //
 // Returns:
-//   - []*v2.Grant: List of grants for the user
-//   - string: Next page token for pagination
 //   - annotations.Annotations: Additional metadata
 //   - error: Any error that occurred during the operation
-func (o *userBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	var grants []*v2.Grant
-	annos := annotations.Annotations{}
+func (o *userBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
 
 	if err := o.client.ensureConnected(ctx); err != nil {
-		return nil, "", nil, err
+		return nil, err
 	}
 
-	// Get the user by username to get their ID
-	users, err := o.client.client.GetUsersByUsername(ctx, resource.DisplayName)
+	realm, err := o.client.realmOfID(ctx, resourceId)
 	if err != nil {
-		return nil, "", nil, err
+		return nil, err
 	}
-	if len(users) == 0 {
-		return nil, "", nil, fmt.Errorf("user not found")
+
+	userID, err := o.client.resolveUserID(ctx, realm, resourceId.Resource)
+	if err != nil {
+		return nil, err
 	}
 
-	userID := *users[0].ID
+	if !o.client.disableUsersOnDelete {
+		if err := o.client.client.DeleteUser(ctx, realm, userID); err != nil {
+			return nil, fmt.Errorf("failed to delete user %s: %w", userID, err)
+		}
+		l.Info("Deleted user", zap.String("user_id", userID))
+		return nil, nil
+	}
 
-	// Get all groups the user is a member of
-	groups, err := o.client.client.GetUserGroups(ctx, userID)
+	user, err := o.client.client.GetUserByID(ctx, realm, userID)
 	if err != nil {
-		return nil, "", nil, err
+		return nil, fmt.Errorf("failed to get user %s: %w", userID, err)
 	}
 
-	for _, group := range groups {
-		groupResource, err := parseIntoGroupResource(group, nil)
-		if err != nil {
-			return nil, "", nil, err
-		}
+	user.Enabled = gocloak.BoolP(false)
+	if err := o.client.client.UpdateUser(ctx, realm, *user); err != nil {
+		return nil, fmt.Errorf("failed to disable user %s: %w", userID, err)
+	}
+
+	if err := o.client.client.LogoutAllSessions(ctx, realm, userID); err != nil {
+		return nil, fmt.Errorf("user %s was disabled but logging out their sessions failed: %w", userID, err)
+	}
+	l.Info("Disabled user and logged out all sessions", zap.String("user_id", userID))
+
+	return nil, nil
+}
+
+// bruteForceSettingTTL bounds how long a realm's brute force detection setting is reused, so it is
+// read once per sync rather than for every page of users.
+const bruteForceSettingTTL = 5 * time.Minute
+
+type bruteForceSetting struct {
+	protected bool
+	readAt    time.Time
+}
 
-		grant := &v2.Grant{
-			Id: fmt.Sprintf("grant:%s:%s", userID, *group.ID),
-			Entitlement: &v2.Entitlement{
-				Id:          fmt.Sprintf("user:%s:group:%s", userID, *group.ID),
-				DisplayName: fmt.Sprintf("Membership in %s", *group.Name),
-				Description: fmt.Sprintf("Membership in the %s group", *group.Name),
-				GrantableTo: []*v2.ResourceType{userResourceType},
-				Slug:        "membership",
-				Resource:    groupResource,
-			},
-			Principal: resource,
+// isBruteForceProtected reports whether the realm has brute force detection enabled, caching the
+// answer per realm.
+func (c *Connector) isBruteForceProtected(ctx context.Context, realm string) (bool, error) {
+	if cached, ok := c.bruteForceSettings.Load(realm); ok {
+		if setting := cached.(bruteForceSetting); time.Since(setting.readAt) < bruteForceSettingTTL {
+			return setting.protected, nil
 		}
+	}
 
-		grants = append(grants, grant)
+	protected, err := c.client.IsBruteForceProtected(ctx, realm)
+	if err != nil {
+		return false, err
 	}
+	c.bruteForceSettings.Store(realm, bruteForceSetting{protected: protected, readAt: time.Now()})
+
+	return protected, nil
+}
 
-	return grants, "", annos, nil
+// accountCreationSchema lists the fields ConductorOne asks for when creating a Keycloak user.
+var accountCreationSchema = &v2.ConnectorAccountCreationSchema{
+	FieldMap: map[string]*v2.ConnectorAccountCreationSchema_Field{
+		"username": {
+			DisplayName: "Username",
+			Required:    true,
+			Description: "The username of the new user.",
+			Placeholder: "jdoe",
+			Order:       1,
+			Field:       &v2.ConnectorAccountCreationSchema_Field_StringField{StringField: &v2.ConnectorAccountCreationSchema_StringField{}},
+		},
+		"email": {
+			DisplayName: "Email",
+			Required:    true,
+			Description: "The email address of the new user.",
+			Placeholder: "jdoe@example.com",
+			Order:       2,
+			Field:       &v2.ConnectorAccountCreationSchema_Field_StringField{StringField: &v2.ConnectorAccountCreationSchema_StringField{}},
+		},
+		"first_name": {
+			DisplayName: "First name",
+			Required:    false,
+			Placeholder: "Jane",
+			Order:       3,
+			Field:       &v2.ConnectorAccountCreationSchema_Field_StringField{StringField: &v2.ConnectorAccountCreationSchema_StringField{}},
+		},
+		"last_name": {
+			DisplayName: "Last name",
+			Required:    false,
+			Placeholder: "Doe",
+			Order:       4,
+			Field:       &v2.ConnectorAccountCreationSchema_Field_StringField{StringField: &v2.ConnectorAccountCreationSchema_StringField{}},
+		},
+		"email_verified": {
+			DisplayName: "Email verified",
+			Required:    false,
+			Description: "Mark the email address as already verified.",
+			Order:       5,
+			Field:       &v2.ConnectorAccountCreationSchema_Field_BoolField{BoolField: &v2.ConnectorAccountCreationSchema_BoolField{}},
+		},
+		"groups": {
+			DisplayName: "Groups",
+			Required:    false,
+			Description: "Paths of the groups to add the new user to, e.g. /engineering/platform.",
+			Order:       6,
+			Field:       &v2.ConnectorAccountCreationSchema_Field_StringListField{StringListField: &v2.ConnectorAccountCreationSchema_StringListField{}},
+		},
+		"realm": {
+			DisplayName: "Realm",
+			Required:    false,
+			Description: "The realm to create the user in. Required when the connector syncs more than one realm.",
+			Order:       7,
+			Field:       &v2.ConnectorAccountCreationSchema_Field_StringField{StringField: &v2.ConnectorAccountCreationSchema_StringField{}},
+		},
+	},
 }
 
 // newUserBuilder creates a new instance of userBuilder.
@@ -197,7 +420,16 @@ func newUserBuilder(client *Connector) *userBuilder {
 //   - *v2.Resource: The converted Baton resource
 //   - error: Any conversion error that occurred
 func parseIntoUserResource(user *gocloak.User, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	return parseIntoUserResourceWithLockout(user, nil, parentResourceID)
+}
+
+// parseIntoUserResourceWithLockout converts a Keycloak user into a Baton SDK user resource, taking the
+// user's brute force detection status into account when it is known.
+func parseIntoUserResourceWithLockout(user *gocloak.User, lockout *gocloak.BruteForceStatus, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
 	var userStatus = v2.UserTrait_Status_STATUS_ENABLED
+	if user.Enabled != nil && !*user.Enabled {
+		userStatus = v2.UserTrait_Status_STATUS_DISABLED
+	}
 
 	username := ""
 	if user.Username != nil {
@@ -209,18 +441,30 @@ func parseIntoUserResource(user *gocloak.User, parentResourceID *v2.ResourceId)
 		"email":     safeString(user.Email),
 		"firstName": safeString(user.FirstName),
 		"lastName":  safeString(user.LastName),
+		"enabled":   user.Enabled == nil || *user.Enabled,
+	}
+
+	statusOption := resource.WithStatus(userStatus)
+	// A temporarily locked out user is still enabled in Keycloak but can't log in until the lockout expires.
+	if userStatus == v2.UserTrait_Status_STATUS_ENABLED && lockout != nil && lockout.Disabled != nil && *lockout.Disabled {
+		profile["locked"] = true
+		if lockout.NumFailures != nil {
+			profile["loginFailures"] = *lockout.NumFailures
+		}
+		statusOption = resource.WithDetailedStatus(v2.UserTrait_Status_STATUS_DISABLED, "temporarily locked out by brute force detection")
 	}
 
 	userTraits := []resource.UserTraitOption{
 		resource.WithUserProfile(profile),
 		resource.WithUserLogin(username),
-		resource.WithStatus(userStatus),
+		statusOption,
 	}
 
+	// The Keycloak ID is immutable, unlike the username, so it keeps the resource stable across renames.
 	ret, err := resource.NewUserResource(
 		username,
 		userResourceType,
-		username,
+		*user.ID,
 		userTraits,
 		resource.WithParentResourceID(parentResourceID),
 	)
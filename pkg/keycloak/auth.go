@@ -0,0 +1,129 @@
+package keycloak
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// AuthMethod selects how the client authenticates against Keycloak.
+type AuthMethod string
+
+const (
+	// AuthClientSecret logs in with the client credentials grant and a client secret.
+	AuthClientSecret AuthMethod = "client_secret"
+	// AuthPrivateKeyJWT logs in with the client credentials grant and a JWT signed with the client's private key.
+	AuthPrivateKeyJWT AuthMethod = "private_key_jwt"
+	// AuthMTLS logs in with the client credentials grant, authenticating the client by its TLS certificate.
+	AuthMTLS AuthMethod = "mtls"
+	// AuthPassword logs in as an admin user with the password grant, for setups without a service account.
+	AuthPassword AuthMethod = "password"
+)
+
+// signedJWTLifetime is how long a client assertion is valid for. It only has to outlive the token request.
+const signedJWTLifetime = time.Minute
+
+type authentication struct {
+	method        AuthMethod
+	signingKey    interface{}
+	signingMethod jwt.SigningMethod
+	certificate   *tls.Certificate
+	username      string
+	password      string
+}
+
+// WithPrivateKeyJWT authenticates the client with JWTs signed by its private key instead of a
+// client secret. See LoadPrivateKey.
+func WithPrivateKeyJWT(key interface{}, method jwt.SigningMethod) Option {
+	return func(o *clientOptions) {
+		o.auth = authentication{method: AuthPrivateKeyJWT, signingKey: key, signingMethod: method}
+	}
+}
+
+// WithClientCertificate authenticates the client by presenting a TLS client certificate, on top of
+// any other TLS settings.
+func WithClientCertificate(certificate tls.Certificate) Option {
+	return func(o *clientOptions) {
+		o.auth = authentication{method: AuthMTLS, certificate: &certificate}
+	}
+}
+
+// WithPassword logs in as a user with the password grant instead of using the client's service account.
+func WithPassword(username, password string) Option {
+	return func(o *clientOptions) {
+		o.auth = authentication{method: AuthPassword, username: username, password: password}
+	}
+}
+
+// LoadPrivateKey reads a PEM encoded RSA or EC private key and picks the matching JWT signing method.
+func LoadPrivateKey(path string) (interface{}, jwt.SigningMethod, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return k, jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return k, jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return k, jwt.SigningMethodES512, nil
+		}
+		return nil, nil, fmt.Errorf("unsupported elliptic curve in %s", path)
+	default:
+		return nil, nil, fmt.Errorf("unsupported private key type %T in %s", key, path)
+	}
+}
+
+// login obtains a new token with the configured authentication method. With mTLS the client
+// certificate is presented by the transport, so the request itself carries no secret.
+func (c *Client) login(ctx context.Context) (*gocloak.JWT, error) {
+	switch c.auth.method {
+	case AuthPrivateKeyJWT:
+		expiresAt := jwt.NewNumericDate(time.Now().Add(signedJWTLifetime))
+		return c.client.LoginClientSignedJWT(ctx, c.clientID, c.authRealm, c.auth.signingKey, c.auth.signingMethod, expiresAt)
+	case AuthPassword:
+		return c.client.Login(ctx, c.clientID, c.clientSecret, c.authRealm, c.auth.username, c.auth.password)
+	default:
+		return c.client.LoginClient(ctx, c.clientID, c.clientSecret, c.authRealm)
+	}
+}
+
+// canRefresh reports whether refresh tokens can be redeemed. Refreshing a confidential client's token
+// needs client authentication, which only a secret (or, with mTLS, the transport) provides.
+func (c *Client) canRefresh() bool {
+	return c.auth.method != AuthPrivateKeyJWT
+}
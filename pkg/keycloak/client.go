@@ -2,98 +2,325 @@ package keycloak
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Nerzal/gocloak/v13"
 )
 
+const (
+	// DefaultPageSize is the number of users, groups, roles or clients requested per page.
+	DefaultPageSize = 300
+	// MinPageSize and MaxPageSize bound the configurable page size. Larger pages need fewer requests
+	// but more memory, and risk timing out on slow Keycloak nodes.
+	MinPageSize = 10
+	MaxPageSize = 1000
+)
+
 type Client struct {
 	client       *gocloak.GoCloak
-	realm        string
+	serverURL    string
+	authRealm    string
 	clientID     string
 	clientSecret string
-	token        *gocloak.JWT
+	auth         authentication
+	pageSize     int
+
+	// mu guards the token and its expiry times, which are shared by concurrent callers.
+	mu            sync.Mutex
+	token         *gocloak.JWT
+	accessExpiry  time.Time
+	refreshExpiry time.Time
+
+	// reauthentications counts how often a request was retried after a 401, reported as the
+	// reauthentication_count log field.
+	reauthentications atomic.Int64
+}
+
+// Option customizes a Client.
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	maxAttempts int
+	pageSize    int
+	auth        authentication
+	transport   transportOptions
+}
+
+// WithMaxAttempts sets how often a request is sent before a 429 or 503 response is returned to the
+// caller. One disables retries.
+func WithMaxAttempts(maxAttempts int) Option {
+	return func(o *clientOptions) {
+		o.maxAttempts = maxAttempts
+	}
 }
 
-func NewClient(serverURL, realm, clientID, clientSecret string) *Client {
+// WithPageSize sets the number of users, groups, roles or clients requested per page.
+func WithPageSize(pageSize int) Option {
+	return func(o *clientOptions) {
+		o.pageSize = pageSize
+	}
+}
+
+// NewClient creates a client for the Keycloak admin API. It authenticates against authRealm, and
+// every call names the realm it manages, which may be authRealm itself or, for an admin client in
+// master, any other realm.
+func NewClient(serverURL, authRealm, clientID, clientSecret string, opts ...Option) *Client {
+	options := clientOptions{
+		maxAttempts: DefaultMaxAttempts,
+		pageSize:    DefaultPageSize,
+		auth:        authentication{method: AuthClientSecret},
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	client := gocloak.NewClient(serverURL)
+	configureTransport(client.RestyClient(), options.transport, options.auth.certificate)
+	configureRetries(client.RestyClient(), options.maxAttempts)
+
 	return &Client{
-		client:       gocloak.NewClient(serverURL),
-		realm:        realm,
+		client:       client,
+		serverURL:    strings.TrimRight(serverURL, "/"),
+		authRealm:    authRealm,
 		clientID:     clientID,
 		clientSecret: clientSecret,
+		auth:         options.auth,
+		pageSize:     options.pageSize,
 	}
 }
 
-func (c *Client) Connect(ctx context.Context) error {
-	token, err := c.client.LoginClient(ctx, c.clientID, c.clientSecret, c.realm)
+func (c *Client) AddUserToGroup(ctx context.Context, realm, userID, groupID string) error {
+	return c.doWithReauth(ctx, "AddUserToGroup", func(token string) error {
+		return c.client.AddUserToGroup(ctx, token, realm, userID, groupID)
+	})
+}
+
+func (c *Client) RemoveUserFromGroup(ctx context.Context, realm, userID, groupID string) error {
+	return c.doWithReauth(ctx, "DeleteUserFromGroup", func(token string) error {
+		return c.client.DeleteUserFromGroup(ctx, token, realm, userID, groupID)
+	})
+}
+
+// GetUsers returns a page of the realm's users starting at first. Like the other paged listings it
+// reports whether more pages may follow, which is the case as long as pages come back full.
+func (c *Client) GetUsers(ctx context.Context, realm string, first int) ([]*gocloak.User, bool, error) {
+	max := c.pageSize
+
+	users, err := callWithReauth(ctx, c, "GetUsers", func(token string) ([]*gocloak.User, error) {
+		return c.client.GetUsers(ctx, token, realm, gocloak.GetUsersParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
+	})
 	if err != nil {
-		return err
+		return nil, false, fmt.Errorf("failed to get users: %w", err)
 	}
 
-	c.token = token
-	return nil
+	return users, len(users) == max, nil
 }
 
-func (c *Client) AddUserToGroup(ctx context.Context, userID, groupID string) error {
-	return c.client.AddUserToGroup(ctx, c.token.AccessToken, c.realm, userID, groupID)
-}
+func (c *Client) GetGroupMembers(ctx context.Context, realm, groupID string, first int) ([]*gocloak.User, bool, error) {
+	max := c.pageSize
+
+	users, err := callWithReauth(ctx, c, "GetGroupMembers", func(token string) ([]*gocloak.User, error) {
+		return c.client.GetGroupMembers(ctx, token, realm, groupID, gocloak.GetGroupsParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get group members: %w", err)
+	}
 
-func (c *Client) RemoveUserFromGroup(ctx context.Context, userID, groupID string) error {
-	return c.client.DeleteUserFromGroup(ctx, c.token.AccessToken, c.realm, userID, groupID)
+	return users, len(users) == max, nil
 }
 
-func (c *Client) GetUsers(ctx context.Context, first int) ([]*gocloak.User, string, error) {
-	max := 300
+func (c *Client) GetGroups(ctx context.Context, realm string, first int) ([]*gocloak.Group, bool, error) {
+	max := c.pageSize
 
-	users, err := c.client.GetUsers(ctx, c.token.AccessToken, c.realm, gocloak.GetUsersParams{
-		First: pointer(first),
-		Max:   pointer(max),
+	groups, err := callWithReauth(ctx, c, "GetGroups", func(token string) ([]*gocloak.Group, error) {
+		return c.client.GetGroups(ctx, token, realm, gocloak.GetGroupsParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
 	})
 	if err != nil {
-		return nil, strconv.Itoa(first), fmt.Errorf("failed to get users: %w", err)
+		return nil, false, fmt.Errorf("failed to get groups: %w", err)
 	}
 
-	if len(users) == 0 {
-		return nil, "", nil
+	return groups, len(groups) == max, nil
+}
+
+func (c *Client) GetGroup(ctx context.Context, realm, groupID string) (*gocloak.Group, error) {
+	return callWithReauth(ctx, c, "GetGroup", func(token string) (*gocloak.Group, error) {
+		return c.client.GetGroup(ctx, token, realm, groupID)
+	})
+}
+
+func (c *Client) GetGroupByPath(ctx context.Context, realm, path string) (*gocloak.Group, error) {
+	return callWithReauth(ctx, c, "GetGroupByPath", func(token string) (*gocloak.Group, error) {
+		return c.client.GetGroupByPath(ctx, token, realm, path)
+	})
+}
+
+func (c *Client) CreateGroup(ctx context.Context, realm string, group gocloak.Group) (string, error) {
+	return callWithReauth(ctx, c, "CreateGroup", func(token string) (string, error) {
+		return c.client.CreateGroup(ctx, token, realm, group)
+	})
+}
+
+func (c *Client) CreateChildGroup(ctx context.Context, realm, parentID string, group gocloak.Group) (string, error) {
+	return callWithReauth(ctx, c, "CreateChildGroup", func(token string) (string, error) {
+		return c.client.CreateChildGroup(ctx, token, realm, parentID, group)
+	})
+}
+
+func (c *Client) DeleteGroup(ctx context.Context, realm, groupID string) error {
+	return c.doWithReauth(ctx, "DeleteGroup", func(token string) error {
+		return c.client.DeleteGroup(ctx, token, realm, groupID)
+	})
+}
+
+// GetChildGroups returns a page of the direct subgroups of a group. Keycloak 23+ only lists subgroups
+// through the /children endpoint; older releases only accept POST there, answering a GET with 404 or
+// 405, and embed the subgroups in the group itself.
+func (c *Client) GetChildGroups(ctx context.Context, realm, groupID string, first int) ([]*gocloak.Group, bool, error) {
+	max := c.pageSize
+
+	var groups []*gocloak.Group
+	err := c.getAdmin(ctx, realm, &groups, map[string]string{
+		"first": strconv.Itoa(first),
+		"max":   strconv.Itoa(max),
+	}, "groups", groupID, "children")
+
+	var apiErr *gocloak.APIError
+	if errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusMethodNotAllowed) {
+		group, err := callWithReauth(ctx, c, "GetGroup", func(token string) (*gocloak.Group, error) {
+			return c.client.GetGroup(ctx, token, realm, groupID)
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get group %s: %w", groupID, err)
+		}
+		if group.SubGroups == nil {
+			return nil, false, nil
+		}
+
+		for i := range *group.SubGroups {
+			groups = append(groups, &(*group.SubGroups)[i])
+		}
+		return groups, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get subgroups of %s: %w", groupID, err)
 	}
 
-	return users, strconv.Itoa(first + max), nil
+	return groups, len(groups) == max, nil
 }
 
-func (c *Client) GetGroupMembers(ctx context.Context, groupID string) ([]*gocloak.User, error) {
-	return c.client.GetGroupMembers(ctx, c.token.AccessToken, c.realm, groupID, gocloak.GetGroupsParams{})
+func (c *Client) Close() error {
+	return nil
 }
 
-func (c *Client) GetGroups(ctx context.Context, first int) ([]*gocloak.Group, string, error) {
-	max := 300
+// IsBruteForceProtected reports whether the realm has brute force detection enabled.
+func (c *Client) IsBruteForceProtected(ctx context.Context, realm string) (bool, error) {
+	rep, err := c.GetRealm(ctx, realm)
+	if err != nil {
+		return false, err
+	}
 
-	groups, err := c.client.GetGroups(ctx, c.token.AccessToken, c.realm, gocloak.GetGroupsParams{
-		First: pointer(first),
-		Max:   pointer(max),
+	return rep.BruteForceProtected != nil && *rep.BruteForceProtected, nil
+}
+
+// GetRealm returns the settings of a realm.
+func (c *Client) GetRealm(ctx context.Context, realm string) (*gocloak.RealmRepresentation, error) {
+	rep, err := callWithReauth(ctx, c, "GetRealm", func(token string) (*gocloak.RealmRepresentation, error) {
+		return c.client.GetRealm(ctx, token, realm)
 	})
 	if err != nil {
-		return nil, strconv.Itoa(first), fmt.Errorf("failed to get groups: %w", err)
+		return nil, fmt.Errorf("failed to get realm %s: %w", realm, err)
 	}
 
-	if len(groups) == 0 {
-		return nil, "", nil
+	return rep, nil
+}
+
+// GetRealms returns every realm the client is allowed to see.
+func (c *Client) GetRealms(ctx context.Context) ([]*gocloak.RealmRepresentation, error) {
+	realms, err := callWithReauth(ctx, c, "GetRealms", func(token string) ([]*gocloak.RealmRepresentation, error) {
+		return c.client.GetRealms(ctx, token)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get realms: %w", err)
 	}
 
-	return groups, strconv.Itoa(first + max), nil
+	return realms, nil
 }
 
-func (c *Client) GetUserGroups(ctx context.Context, userID string) ([]*gocloak.Group, error) {
-	return c.client.GetUserGroups(ctx, c.token.AccessToken, c.realm, userID, gocloak.GetGroupsParams{})
+func (c *Client) GetUserBruteForceStatus(ctx context.Context, realm, userID string) (*gocloak.BruteForceStatus, error) {
+	status, err := callWithReauth(ctx, c, "GetUserBruteForceDetectionStatus", func(token string) (*gocloak.BruteForceStatus, error) {
+		return c.client.GetUserBruteForceDetectionStatus(ctx, token, realm, userID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get brute force status of user %s: %w", userID, err)
+	}
+
+	return status, nil
 }
 
-func (c *Client) Close() error {
-	return nil
+func (c *Client) CreateUser(ctx context.Context, realm string, user gocloak.User) (string, error) {
+	return callWithReauth(ctx, c, "CreateUser", func(token string) (string, error) {
+		return c.client.CreateUser(ctx, token, realm, user)
+	})
+}
+
+func (c *Client) UpdateUser(ctx context.Context, realm string, user gocloak.User) error {
+	return c.doWithReauth(ctx, "UpdateUser", func(token string) error {
+		return c.client.UpdateUser(ctx, token, realm, user)
+	})
+}
+
+func (c *Client) DeleteUser(ctx context.Context, realm, userID string) error {
+	return c.doWithReauth(ctx, "DeleteUser", func(token string) error {
+		return c.client.DeleteUser(ctx, token, realm, userID)
+	})
+}
+
+// LogoutAllSessions ends every active session of the user.
+func (c *Client) LogoutAllSessions(ctx context.Context, realm, userID string) error {
+	return c.doWithReauth(ctx, "LogoutAllSessions", func(token string) error {
+		return c.client.LogoutAllSessions(ctx, token, realm, userID)
+	})
 }
 
-func (c *Client) GetUsersByUsername(ctx context.Context, username string) ([]*gocloak.User, error) {
-	users, err := c.client.GetUsers(ctx, c.token.AccessToken, c.realm, gocloak.GetUsersParams{
-		Username: pointer(username),
+// ExecuteActionsEmail emails the user a link to perform the given required actions, e.g. UPDATE_PASSWORD.
+func (c *Client) ExecuteActionsEmail(ctx context.Context, realm, userID string, actions []string) error {
+	return c.doWithReauth(ctx, "ExecuteActionsEmail", func(token string) error {
+		return c.client.ExecuteActionsEmail(ctx, token, realm, gocloak.ExecuteActionsEmail{
+			UserID:  pointer(userID),
+			Actions: pointer(actions),
+		})
+	})
+}
+
+func (c *Client) GetUserByID(ctx context.Context, realm, userID string) (*gocloak.User, error) {
+	return callWithReauth(ctx, c, "GetUserByID", func(token string) (*gocloak.User, error) {
+		return c.client.GetUserByID(ctx, token, realm, userID)
+	})
+}
+
+func (c *Client) GetUsersByUsername(ctx context.Context, realm, username string) ([]*gocloak.User, error) {
+	users, err := callWithReauth(ctx, c, "GetUsers", func(token string) ([]*gocloak.User, error) {
+		return c.client.GetUsers(ctx, token, realm, gocloak.GetUsersParams{
+			Username: pointer(username),
+			Exact:    pointer(true),
+		})
 	})
 	if err != nil {
 		return nil, err
@@ -102,6 +329,286 @@ func (c *Client) GetUsersByUsername(ctx context.Context, username string) ([]*go
 	return users, nil
 }
 
+func (c *Client) GetRealmRoles(ctx context.Context, realm string, first int) ([]*gocloak.Role, bool, error) {
+	max := c.pageSize
+
+	roles, err := callWithReauth(ctx, c, "GetRealmRoles", func(token string) ([]*gocloak.Role, error) {
+		return c.client.GetRealmRoles(ctx, token, realm, gocloak.GetRoleParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get realm roles: %w", err)
+	}
+
+	return roles, len(roles) == max, nil
+}
+
+func (c *Client) GetRealmRoleByID(ctx context.Context, realm, roleID string) (*gocloak.Role, error) {
+	return callWithReauth(ctx, c, "GetRealmRoleByID", func(token string) (*gocloak.Role, error) {
+		return c.client.GetRealmRoleByID(ctx, token, realm, roleID)
+	})
+}
+
+func (c *Client) AddRealmRoleToUser(ctx context.Context, realm, userID string, role *gocloak.Role) error {
+	return c.doWithReauth(ctx, "AddRealmRoleToUser", func(token string) error {
+		return c.client.AddRealmRoleToUser(ctx, token, realm, userID, []gocloak.Role{*role})
+	})
+}
+
+func (c *Client) DeleteRealmRoleFromUser(ctx context.Context, realm, userID string, role *gocloak.Role) error {
+	return c.doWithReauth(ctx, "DeleteRealmRoleFromUser", func(token string) error {
+		return c.client.DeleteRealmRoleFromUser(ctx, token, realm, userID, []gocloak.Role{*role})
+	})
+}
+
+// GetRealmRoleUsers returns a page of the users that hold the realm role directly.
+func (c *Client) GetRealmRoleUsers(ctx context.Context, realm, roleName string, first int) ([]*gocloak.User, bool, error) {
+	max := c.pageSize
+
+	users, err := callWithReauth(ctx, c, "GetUsersByRoleName", func(token string) ([]*gocloak.User, error) {
+		return c.client.GetUsersByRoleName(ctx, token, realm, roleName, gocloak.GetUsersByRoleParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get users for role %s: %w", roleName, err)
+	}
+
+	return users, len(users) == max, nil
+}
+
+// GetRealmRoleGroups returns a page of the groups the realm role is mapped to directly.
+// gocloak's GetGroupsByRole does not take paging parameters, so we call the endpoint ourselves.
+func (c *Client) GetRealmRoleGroups(ctx context.Context, realm, roleName string, first int) ([]*gocloak.Group, bool, error) {
+	max := c.pageSize
+
+	var groups []*gocloak.Group
+	err := c.getAdmin(ctx, realm, &groups, map[string]string{
+		"first": strconv.Itoa(first),
+		"max":   strconv.Itoa(max),
+	}, "roles", roleName, "groups")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get groups for role %s: %w", roleName, err)
+	}
+
+	return groups, len(groups) == max, nil
+}
+
+func (c *Client) GetClients(ctx context.Context, realm string, first int) ([]*gocloak.Client, bool, error) {
+	max := c.pageSize
+
+	clients, err := callWithReauth(ctx, c, "GetClients", func(token string) ([]*gocloak.Client, error) {
+		return c.client.GetClients(ctx, token, realm, gocloak.GetClientsParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get clients: %w", err)
+	}
+
+	return clients, len(clients) == max, nil
+}
+
+func (c *Client) GetClientRoles(ctx context.Context, realm, idOfClient string, first int) ([]*gocloak.Role, bool, error) {
+	max := c.pageSize
+
+	roles, err := callWithReauth(ctx, c, "GetClientRoles", func(token string) ([]*gocloak.Role, error) {
+		return c.client.GetClientRoles(ctx, token, realm, idOfClient, gocloak.GetRoleParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get client roles: %w", err)
+	}
+
+	return roles, len(roles) == max, nil
+}
+
+func (c *Client) GetClient(ctx context.Context, realm, idOfClient string) (*gocloak.Client, error) {
+	return callWithReauth(ctx, c, "GetClient", func(token string) (*gocloak.Client, error) {
+		return c.client.GetClient(ctx, token, realm, idOfClient)
+	})
+}
+
+func (c *Client) GetClientRoleByID(ctx context.Context, realm, roleID string) (*gocloak.Role, error) {
+	return callWithReauth(ctx, c, "GetClientRoleByID", func(token string) (*gocloak.Role, error) {
+		return c.client.GetClientRoleByID(ctx, token, realm, roleID)
+	})
+}
+
+func (c *Client) AddClientRoleToUser(ctx context.Context, realm, idOfClient, userID string, role *gocloak.Role) error {
+	return c.doWithReauth(ctx, "AddClientRolesToUser", func(token string) error {
+		return c.client.AddClientRolesToUser(ctx, token, realm, idOfClient, userID, []gocloak.Role{*role})
+	})
+}
+
+func (c *Client) DeleteClientRoleFromUser(ctx context.Context, realm, idOfClient, userID string, role *gocloak.Role) error {
+	return c.doWithReauth(ctx, "DeleteClientRolesFromUser", func(token string) error {
+		return c.client.DeleteClientRolesFromUser(ctx, token, realm, idOfClient, userID, []gocloak.Role{*role})
+	})
+}
+
+func (c *Client) AddClientRoleToGroup(ctx context.Context, realm, idOfClient, groupID string, role *gocloak.Role) error {
+	return c.doWithReauth(ctx, "AddClientRolesToGroup", func(token string) error {
+		return c.client.AddClientRolesToGroup(ctx, token, realm, idOfClient, groupID, []gocloak.Role{*role})
+	})
+}
+
+func (c *Client) DeleteClientRoleFromGroup(ctx context.Context, realm, idOfClient, groupID string, role *gocloak.Role) error {
+	return c.doWithReauth(ctx, "DeleteClientRoleFromGroup", func(token string) error {
+		return c.client.DeleteClientRoleFromGroup(ctx, token, realm, idOfClient, groupID, []gocloak.Role{*role})
+	})
+}
+
+// GetClientRoleUsers returns a page of the users that hold the client role directly.
+func (c *Client) GetClientRoleUsers(ctx context.Context, realm, idOfClient, roleName string, first int) ([]*gocloak.User, bool, error) {
+	max := c.pageSize
+
+	users, err := callWithReauth(ctx, c, "GetUsersByClientRoleName", func(token string) ([]*gocloak.User, error) {
+		return c.client.GetUsersByClientRoleName(ctx, token, realm, idOfClient, roleName, gocloak.GetUsersByRoleParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get users for client role %s: %w", roleName, err)
+	}
+
+	return users, len(users) == max, nil
+}
+
+// GetClientRoleGroups returns a page of the groups the client role is mapped to directly.
+func (c *Client) GetClientRoleGroups(ctx context.Context, realm, idOfClient, roleName string, first int) ([]*gocloak.Group, bool, error) {
+	max := c.pageSize
+
+	var groups []*gocloak.Group
+	err := c.getAdmin(ctx, realm, &groups, map[string]string{
+		"first": strconv.Itoa(first),
+		"max":   strconv.Itoa(max),
+	}, "clients", idOfClient, "roles", roleName, "groups")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get groups for client role %s: %w", roleName, err)
+	}
+
+	return groups, len(groups) == max, nil
+}
+
+// GetCompositeRoles returns every realm and client role in the realm that is a composite.
+func (c *Client) GetCompositeRoles(ctx context.Context, realm string) ([]*gocloak.Role, error) {
+	max := c.pageSize
+
+	var composites []*gocloak.Role
+	isComposite := func(role *gocloak.Role) bool {
+		return role.Composite != nil && *role.Composite
+	}
+
+	for first := 0; ; first += max {
+		roles, err := callWithReauth(ctx, c, "GetRealmRoles", func(token string) ([]*gocloak.Role, error) {
+			return c.client.GetRealmRoles(ctx, token, realm, gocloak.GetRoleParams{
+				First: pointer(first),
+				Max:   pointer(max),
+			})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get realm roles: %w", err)
+		}
+
+		for _, role := range roles {
+			if isComposite(role) {
+				composites = append(composites, role)
+			}
+		}
+		if len(roles) < max {
+			break
+		}
+	}
+
+	for clientFirst := 0; ; clientFirst += max {
+		clients, err := callWithReauth(ctx, c, "GetClients", func(token string) ([]*gocloak.Client, error) {
+			return c.client.GetClients(ctx, token, realm, gocloak.GetClientsParams{
+				First: pointer(clientFirst),
+				Max:   pointer(max),
+			})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get clients: %w", err)
+		}
+
+		for _, client := range clients {
+			for first := 0; ; first += max {
+				roles, err := callWithReauth(ctx, c, "GetClientRoles", func(token string) ([]*gocloak.Role, error) {
+					return c.client.GetClientRoles(ctx, token, realm, *client.ID, gocloak.GetRoleParams{
+						First: pointer(first),
+						Max:   pointer(max),
+					})
+				})
+				if err != nil {
+					return nil, fmt.Errorf("failed to get client roles: %w", err)
+				}
+
+				for _, role := range roles {
+					if isComposite(role) {
+						composites = append(composites, role)
+					}
+				}
+				if len(roles) < max {
+					break
+				}
+			}
+		}
+		if len(clients) < max {
+			break
+		}
+	}
+
+	return composites, nil
+}
+
+// GetRoleComposites returns the realm and client roles directly contained in a composite role.
+func (c *Client) GetRoleComposites(ctx context.Context, realm, roleID string) ([]*gocloak.Role, error) {
+	roles, err := callWithReauth(ctx, c, "GetCompositeRolesByRoleID", func(token string) ([]*gocloak.Role, error) {
+		return c.client.GetCompositeRolesByRoleID(ctx, token, realm, roleID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get composites of role %s: %w", roleID, err)
+	}
+
+	return roles, nil
+}
+
+// getAdmin issues a GET against the admin API of a realm for endpoints gocloak doesn't cover.
+func (c *Client) getAdmin(ctx context.Context, realm string, result interface{}, params map[string]string, path ...string) error {
+	segments := []string{c.serverURL, "admin", "realms", url.PathEscape(realm)}
+	for _, p := range path {
+		segments = append(segments, url.PathEscape(p))
+	}
+
+	endpoint := strings.Join(segments, "/")
+
+	return c.doWithReauth(ctx, "GET "+endpoint, func(token string) error {
+		resp, err := c.client.GetRequestWithBearerAuth(ctx, token).
+			SetResult(result).
+			SetQueryParams(params).
+			Get(endpoint)
+		if err != nil {
+			return err
+		}
+		if resp.IsError() {
+			return &gocloak.APIError{
+				Code:    resp.StatusCode(),
+				Message: resp.Status(),
+			}
+		}
+
+		return nil
+	})
+}
+
 func pointer[T any](v T) *T {
 	return &v
 }
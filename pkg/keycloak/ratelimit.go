@@ -0,0 +1,121 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/ratelimit"
+	"github.com/go-resty/resty/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type rateLimitRecorderKey struct{}
+
+// RateLimitRecorder keeps the rate limit information of the last response Keycloak (or the gateway
+// in front of it) returned for requests made with its context.
+type RateLimitRecorder struct {
+	mu          sync.Mutex
+	description *v2.RateLimitDescription
+}
+
+// WithRateLimitRecorder returns a context whose requests report their rate limit information to the
+// returned recorder.
+func WithRateLimitRecorder(ctx context.Context) (context.Context, *RateLimitRecorder) {
+	recorder := &RateLimitRecorder{}
+	return context.WithValue(ctx, rateLimitRecorderKey{}, recorder), recorder
+}
+
+// Annotations returns the recorded rate limit information as annotations for the SDK. They are
+// empty if no response carried any.
+func (r *RateLimitRecorder) Annotations() annotations.Annotations {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	annos := annotations.Annotations{}
+	if r.description != nil {
+		annos.WithRateLimiting(r.description)
+	}
+	return annos
+}
+
+func (r *RateLimitRecorder) record(description *v2.RateLimitDescription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.description = description
+}
+
+// overLimit returns the recorded rate limit information if the last response carrying any was
+// turned away for being over the limit.
+func (r *RateLimitRecorder) overLimit() *v2.RateLimitDescription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.description == nil || r.description.Status != v2.RateLimitDescription_STATUS_OVERLIMIT {
+		return nil
+	}
+	return proto.Clone(r.description).(*v2.RateLimitDescription)
+}
+
+// rateLimitError turns a request that still failed with 429 or 503 after retrying into an Unavailable
+// error, which the SDK retries later. The rate limit information recorded for the request's context is
+// attached, so the SDK waits until Keycloak said it can take requests again.
+func rateLimitError(ctx context.Context, err error) error {
+	var apiErr *gocloak.APIError
+	if !errors.As(err, &apiErr) || (apiErr.Code != http.StatusTooManyRequests && apiErr.Code != http.StatusServiceUnavailable) {
+		return err
+	}
+
+	st := status.New(codes.Unavailable, "Keycloak is rate limiting requests or unavailable")
+	if recorder, ok := ctx.Value(rateLimitRecorderKey{}).(*RateLimitRecorder); ok {
+		if description := recorder.overLimit(); description != nil {
+			// The SDK spreads the wait until the reset over the limit, and ignores descriptions without
+			// one, as is the case when Keycloak only sent Retry-After.
+			if description.Limit == 0 {
+				description.Limit = 1
+			}
+			if withDetails, detailsErr := st.WithDetails(description); detailsErr == nil {
+				st = withDetails
+			}
+		}
+	}
+
+	return errors.Join(st.Err(), err)
+}
+
+// recordRateLimit is a resty response middleware passing rate limit headers on to the recorder of
+// the request's context, if there is one.
+func recordRateLimit(_ *resty.Client, resp *resty.Response) error {
+	recorder, ok := resp.Request.Context().Value(rateLimitRecorderKey{}).(*RateLimitRecorder)
+	if !ok {
+		return nil
+	}
+
+	header := resp.Header()
+	description, err := ratelimit.ExtractRateLimitData(resp.StatusCode(), &header)
+	if err != nil || description == nil {
+		// Malformed rate limit headers shouldn't fail the request itself.
+		return nil
+	}
+
+	// A 503 asking us to come back later is the gateway shedding load, which is a rate limit as well.
+	if resp.StatusCode() == http.StatusServiceUnavailable && header.Get("Retry-After") != "" {
+		description.Status = v2.RateLimitDescription_STATUS_OVERLIMIT
+		description.Remaining = 0
+	}
+
+	// Keycloak doesn't send rate limit headers itself, so most responses carry nothing worth reporting.
+	if description.Status == v2.RateLimitDescription_STATUS_UNSPECIFIED && description.Limit == 0 {
+		return nil
+	}
+
+	recorder.record(description)
+	return nil
+}
@@ -0,0 +1,107 @@
+package keycloak
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultMaxAttempts is how often a request is sent before a 429 or 503 is returned to the caller.
+	DefaultMaxAttempts = 5
+
+	retryMinWait = 1 * time.Second
+	// retryMaxWait is the longest backoff, and the longest Retry-After waited out before retrying.
+	// Requests asked to wait longer fail, leaving the wait to the SDK.
+	retryMaxWait = 60 * time.Second
+)
+
+// configureRetries makes the HTTP client retry requests Keycloak rejected with 429 Too Many Requests,
+// and reads it rejected with 503 Service Unavailable. It waits as long as Retry-After asks for, and backs off exponentially
+// with jitter when the header is missing.
+func configureRetries(rc *resty.Client, maxAttempts int) {
+	rc.OnAfterResponse(recordRateLimit)
+
+	if maxAttempts <= 1 {
+		return
+	}
+
+	rc.SetRetryCount(maxAttempts - 1).
+		SetRetryWaitTime(retryMinWait).
+		SetRetryMaxWaitTime(retryMaxWait).
+		SetRetryAfter(retryAfter).
+		AddRetryCondition(func(resp *resty.Response, _ error) bool {
+			if !isRetryable(resp) {
+				return false
+			}
+			// Retrying before Retry-After has passed would only be rejected again. The request fails
+			// instead and callWithReauth hands the rate limit to the SDK, which waits before trying again.
+			if wait, _ := retryAfter(nil, resp); wait > retryMaxWait {
+				ctxzap.Extract(resp.Request.Context()).Warn("Keycloak asked to wait longer than the connector retries for, failing request",
+					zap.String("method", resp.Request.Method),
+					zap.String("url", resp.Request.URL),
+					zap.Int("status_code", resp.StatusCode()),
+					zap.Duration("retry_after", wait),
+					zap.Duration("max_wait", retryMaxWait),
+				)
+				return false
+			}
+			return true
+		}).
+		AddRetryHook(func(resp *resty.Response, _ error) {
+			// Retry hooks also run after the last attempt, when nothing is retried anymore.
+			if resp == nil || resp.Request == nil || resp.Request.Attempt >= maxAttempts {
+				return
+			}
+			ctxzap.Extract(resp.Request.Context()).Warn("Keycloak is rate limiting or unavailable, retrying request",
+				zap.String("method", resp.Request.Method),
+				zap.String("url", resp.Request.URL),
+				zap.Int("status_code", resp.StatusCode()),
+				zap.Int("attempt", resp.Request.Attempt),
+				zap.Int("max_attempts", maxAttempts),
+				zap.String("retry_after", resp.Header().Get("Retry-After")),
+			)
+		})
+}
+
+// isRetryable reports whether a request should be sent again. A 429 means the request was turned away
+// before Keycloak processed it, so any request is retried. A 503 may come from a gateway timing out on a
+// request that did reach Keycloak, and sending a create or role mapping again would fail with a 409, so
+// only reads are retried.
+func isRetryable(resp *resty.Response) bool {
+	if resp == nil || resp.Request == nil {
+		return false
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return resp.Request.Method == http.MethodGet || resp.Request.Method == http.MethodHead
+	default:
+		return false
+	}
+}
+
+// retryAfter returns the wait the response's Retry-After header asks for, either in seconds or as an
+// HTTP date. Zero makes resty fall back to its exponential backoff.
+func retryAfter(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+	value := resp.Header().Get("Retry-After")
+	if value == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	if at, err := http.ParseTime(value); err == nil && time.Now().Before(at) {
+		return time.Until(at), nil
+	}
+
+	return 0, nil
+}
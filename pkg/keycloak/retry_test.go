@@ -0,0 +1,141 @@
+package keycloak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testRealm = "test"
+
+// newTestClient returns a client for a fake Keycloak that hands out tokens and answers every admin API
+// request with handler, counting the requests handler saw.
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *atomic.Int32) {
+	t.Helper()
+
+	requests := &atomic.Int32{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/"+testRealm+"/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+		})
+	})
+	mux.HandleFunc("/admin/", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		handler(w, r)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return NewClient(server.URL, testRealm, "baton", "secret"), requests
+}
+
+func TestRetriesRateLimitedRequestUntilItSucceeds(t *testing.T) {
+	var calls atomic.Int32
+	client, requests := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]*gocloak.User{{ID: gocloak.StringP("user-1")}})
+	})
+
+	users, _, err := client.GetUsers(context.Background(), testRealm, 0)
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != 1 || *users[0].ID != "user-1" {
+		t.Errorf("got users %v, want user-1", users)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("sent %d requests, want 3", n)
+	}
+}
+
+func TestDoesNotRetryUnavailableWrites(t *testing.T) {
+	client, requests := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	_, err := client.CreateUser(context.Background(), testRealm, gocloak.User{Username: gocloak.StringP("jdoe")})
+	if err == nil {
+		t.Fatal("CreateUser succeeded, want an error")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("got code %s, want %s", code, codes.Unavailable)
+	}
+}
+
+func TestFailsWithRateLimitWhenRetryAfterExceedsMaxWait(t *testing.T) {
+	client, requests := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	ctx, recorder := WithRateLimitRecorder(context.Background())
+	start := time.Now()
+	_, _, err := client.GetUsers(ctx, testRealm, 0)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("GetUsers succeeded, want an error")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+	if elapsed >= retryMinWait {
+		t.Errorf("took %s, want the request to fail without waiting", elapsed)
+	}
+
+	// Callers still see the Keycloak error.
+	var apiErr *gocloak.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusTooManyRequests {
+		t.Errorf("got %v, want a 429 API error", err)
+	}
+
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unavailable {
+		t.Fatalf("got %v, want an %s status", err, codes.Unavailable)
+	}
+	var description *v2.RateLimitDescription
+	for _, detail := range st.Details() {
+		if d, ok := detail.(*v2.RateLimitDescription); ok {
+			description = d
+		}
+	}
+	if description == nil {
+		t.Fatalf("status %v carries no rate limit description", st.Proto())
+	}
+	if description.Status != v2.RateLimitDescription_STATUS_OVERLIMIT {
+		t.Errorf("got rate limit status %s, want %s", description.Status, v2.RateLimitDescription_STATUS_OVERLIMIT)
+	}
+	if description.Limit != 1 {
+		t.Errorf("got limit %d, want 1", description.Limit)
+	}
+	if wait := time.Until(description.ResetAt.AsTime()); wait < 110*time.Second || wait > 120*time.Second {
+		t.Errorf("rate limit resets in %s, want about 120s", wait)
+	}
+
+	if len(recorder.Annotations()) != 1 {
+		t.Errorf("got %d annotations, want the rate limit", len(recorder.Annotations()))
+	}
+}
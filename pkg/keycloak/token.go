@@ -0,0 +1,124 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// tokenExpirySkew is how long before expiry a token is considered expired, so requests
+// never go out with a token that lapses in flight.
+const tokenExpirySkew = 30 * time.Second
+
+// Connect makes sure the client holds a usable access token. It is cheap to call before every
+// request: the token is only refreshed, or a new login performed, when it is about to expire.
+func (c *Client) Connect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.token != nil && now.Add(tokenExpirySkew).Before(c.accessExpiry) {
+		return nil
+	}
+
+	l := ctxzap.Extract(ctx)
+
+	// Client credential grants usually don't come with a refresh token, in which case we log in again.
+	if c.token != nil && c.token.RefreshToken != "" && c.canRefresh() && now.Add(tokenExpirySkew).Before(c.refreshExpiry) {
+		token, err := c.client.RefreshToken(ctx, c.token.RefreshToken, c.clientID, c.clientSecret, c.authRealm)
+		if err == nil {
+			c.setToken(token, now)
+			l.Debug("refreshed Keycloak access token", zap.Time("expires_at", c.accessExpiry))
+			return nil
+		}
+		l.Warn("failed to refresh Keycloak access token, logging in again", zap.Error(err))
+	}
+
+	token, err := c.login(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.setToken(token, now)
+	l.Debug("obtained Keycloak access token", zap.String("auth_method", string(c.auth.method)), zap.Time("expires_at", c.accessExpiry))
+	return nil
+}
+
+// setToken stores a new token and works out when it and its refresh token expire. c.mu must be held.
+func (c *Client) setToken(token *gocloak.JWT, issuedAt time.Time) {
+	c.token = token
+	c.accessExpiry = issuedAt.Add(time.Duration(token.ExpiresIn) * time.Second)
+	c.refreshExpiry = issuedAt.Add(time.Duration(token.RefreshExpiresIn) * time.Second)
+}
+
+// accessToken returns the current access token.
+func (c *Client) accessToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == nil {
+		return ""
+	}
+	return c.token.AccessToken
+}
+
+// invalidateToken forgets the given access token so the next Connect logs in again. A token that
+// has already been replaced by a concurrent caller is left alone.
+func (c *Client) invalidateToken(accessToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != nil && c.token.AccessToken == accessToken {
+		c.token = nil
+	}
+}
+
+// callWithReauth runs a request with a current access token, renewing it first if it is about to
+// expire, so long loops of requests don't run into expired tokens. If Keycloak still answers 401
+// because the token was revoked, it authenticates again and retries the request once.
+func callWithReauth[T any](ctx context.Context, c *Client, operation string, fn func(token string) (T, error)) (T, error) {
+	if err := c.Connect(ctx); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	token := c.accessToken()
+	ret, err := fn(token)
+	if !isUnauthorized(err) {
+		return ret, rateLimitError(ctx, err)
+	}
+
+	count := c.reauthentications.Add(1)
+	ctxzap.Extract(ctx).Info("Keycloak rejected the access token, authenticating again",
+		zap.String("operation", operation),
+		zap.Int64("reauthentication_count", count),
+		zap.Error(err),
+	)
+
+	c.invalidateToken(token)
+	if err := c.Connect(ctx); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	ret, err = fn(c.accessToken())
+	return ret, rateLimitError(ctx, err)
+}
+
+// doWithReauth is callWithReauth for requests that only return an error.
+func (c *Client) doWithReauth(ctx context.Context, operation string, fn func(token string) error) error {
+	_, err := callWithReauth(ctx, c, operation, func(token string) (struct{}, error) {
+		return struct{}{}, fn(token)
+	})
+	return err
+}
+
+func isUnauthorized(err error) bool {
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized
+}
@@ -0,0 +1,86 @@
+package keycloak
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+type transportOptions struct {
+	rootCAs            *x509.CertPool
+	insecureSkipVerify bool
+	proxyURL           string
+	timeout            time.Duration
+}
+
+// WithRootCAs trusts the given certificate authorities, such as an internal CA, in addition to the
+// system roots. See LoadCABundle.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(o *clientOptions) {
+		o.transport.rootCAs = pool
+	}
+}
+
+// WithInsecureSkipVerify disables TLS certificate verification. Only meant for lab environments.
+func WithInsecureSkipVerify() Option {
+	return func(o *clientOptions) {
+		o.transport.insecureSkipVerify = true
+	}
+}
+
+// WithProxy sends requests through an HTTP or HTTPS proxy instead of the one from the environment.
+func WithProxy(proxyURL string) Option {
+	return func(o *clientOptions) {
+		o.transport.proxyURL = proxyURL
+	}
+}
+
+// WithTimeout bounds how long a single request, including reading the response, may take.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.transport.timeout = timeout
+	}
+}
+
+// LoadCABundle reads a PEM file of CA certificates and adds them to the system roots.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+
+	return pool, nil
+}
+
+// configureTransport applies the TLS, proxy and timeout settings to the HTTP client. The client
+// certificate of the mTLS auth method is part of the TLS configuration as well.
+func configureTransport(rc *resty.Client, transport transportOptions, certificate *tls.Certificate) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		RootCAs:            transport.rootCAs,
+		InsecureSkipVerify: transport.insecureSkipVerify, //nolint:gosec // Opt-in for lab environments, logged loudly.
+	}
+	if certificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*certificate}
+	}
+	rc.SetTLSClientConfig(tlsConfig)
+
+	if transport.proxyURL != "" {
+		rc.SetProxy(transport.proxyURL)
+	}
+	if transport.timeout > 0 {
+		rc.SetTimeout(transport.timeout)
+	}
+}
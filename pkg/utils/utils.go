@@ -1,20 +1,57 @@
 package utils
 
 import (
+	"encoding/json"
+	"fmt"
 	"strconv"
 
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 )
 
-func ParseToken(pToken *pagination.Token) int {
-	if pToken == nil {
-		return 0
+// PageToken is the pagination state carried between List, Entitlements and Grants calls. Offset is
+// the position of the next page in the Keycloak listing; State holds whatever else a resource needs
+// to remember about where it left off.
+type PageToken struct {
+	Offset int               `json:"offset"`
+	State  map[string]string `json:"state,omitempty"`
+}
+
+// ParseToken decodes a page token. An empty token is the first page. Plain integer offsets, as
+// issued by older versions of the connector, are still accepted.
+func ParseToken(pToken *pagination.Token) (*PageToken, error) {
+	if pToken == nil || pToken.Token == "" {
+		return &PageToken{}, nil
+	}
+
+	token := &PageToken{}
+	if offset, err := strconv.Atoi(pToken.Token); err == nil {
+		token.Offset = offset
+	} else if err := json.Unmarshal([]byte(pToken.Token), token); err != nil {
+		return nil, fmt.Errorf("invalid page token %q: %w", pToken.Token, err)
+	}
+	if token.Offset < 0 {
+		return nil, fmt.Errorf("invalid page token %q: negative offset", pToken.Token)
 	}
 
-	token, err := strconv.Atoi(pToken.Token)
+	return token, nil
+}
+
+// Next returns the token for the page after one holding count items, keeping the token's state.
+// It returns an empty token when there are no more pages.
+func (t *PageToken) Next(count int, hasMore bool) (string, error) {
+	if !hasMore {
+		return "", nil
+	}
+
+	return (&PageToken{Offset: t.Offset + count, State: t.State}).Marshal()
+}
+
+// Marshal encodes the token for the SDK.
+func (t *PageToken) Marshal() (string, error) {
+	data, err := json.Marshal(t)
 	if err != nil {
-		return 0
+		return "", fmt.Errorf("failed to encode page token: %w", err)
 	}
 
-	return token
+	return string(data), nil
 }